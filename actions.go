@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Case priorities, most pressing first
+var priorities = []string{"urgent", "high", "normal", "low"}
+
+// ActionItem is an open case someone has to follow up on
+type ActionItem struct {
+	Case
+	Where       string `json:"where"`       // Unit, a room or an item of their inventory
+	Responsible string `json:"responsible"` // Name of the signature whose Role is the Assignee
+}
+
+// forEachCase calls fn with the JSON path and location of every case of the report
+func (r Report) forEachCase(fn func(field, where string, c Case)) {
+	inventory := func(field, where string, items []Item) {
+		for i, item := range items {
+			for j, c := range item.Cases {
+				fn(fmt.Sprintf("%s.inventory[%d].cases[%d]", field, i, j), where+" – "+item.Name, c)
+			}
+		}
+	}
+	for j, c := range r.Cases {
+		fn(fmt.Sprintf("report.cases[%d]", j), "Unit", c)
+	}
+	inventory("report", "Unit", r.Inventory)
+	for i, room := range r.Rooms {
+		field := fmt.Sprintf("report.rooms[%d]", i)
+		for j, c := range room.Cases {
+			fn(fmt.Sprintf("%s.cases[%d]", field, j), room.Name, c)
+		}
+		inventory(field, room.Name, room.Inventory)
+	}
+}
+
+// FollowUp tells whether anything was planned for the case
+func (c Case) FollowUp() bool {
+	return c.Priority != "" || c.Assignee != "" || c.Due != "" || c.EstimatedCost > 0
+}
+
+// Cost is the EstimatedCost for templates, empty when there is none
+func (c Case) Cost() string {
+	if c.EstimatedCost <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", c.EstimatedCost)
+}
+
+// signatureFor is the first signature with role, matched regardless of case
+func (ir InspectionReport) signatureFor(role string) (Signature, bool) {
+	for _, s := range ir.Signatures {
+		if sameName(s.Role, role) {
+			return s, true
+		}
+	}
+	return Signature{}, false
+}
+
+// ActionItems are the open cases with a follow-up, soonest due then most pressing first
+func (ir InspectionReport) ActionItems() (items []ActionItem) {
+	ir.Report.forEachCase(func(_, where string, c Case) {
+		if !c.Open() || !c.FollowUp() {
+			return
+		}
+		item := ActionItem{Case: c, Where: where}
+		if s, ok := ir.signatureFor(c.Assignee); ok {
+			item.Responsible = s.Name
+		}
+		items = append(items, item)
+	})
+	rank := func(priority string) int {
+		for i, p := range priorities {
+			if p == priority {
+				return i
+			}
+		}
+		return len(priorities)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Due != b.Due {
+			// Dates are YYYY-MM-DD, those without one last
+			return b.Due == "" || (a.Due != "" && a.Due < b.Due)
+		}
+		return rank(a.Priority) < rank(b.Priority)
+	})
+	return items
+}
+
+// validateAssignees requires every Assignee to be the Role of a signature
+func validateAssignees(ir InspectionReport, v *ValidationErrors) {
+	var roles []string
+	for _, s := range ir.Signatures {
+		if s.Role != "" {
+			roles = append(roles, s.Role)
+		}
+	}
+	if len(ir.Signatures) == 0 {
+		return // Reported by the schema
+	}
+	ir.Report.forEachCase(func(field, _ string, c Case) {
+		if c.Assignee == "" {
+			return
+		}
+		if _, ok := ir.signatureFor(c.Assignee); !ok {
+			v.add(field+".assignee", "must be the role of a signature: %s", strings.Join(roles, ", "))
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestActionItems(t *testing.T) {
+	ir := testReport(t)
+	ir.Report.Cases = append(ir.Report.Cases,
+		Case{Title: "Repaint hallway", Status: "Confirmed", Priority: "urgent", Due: "2018-08-31"},
+		Case{Title: "Replace fuse", Status: "Resolved", Priority: "urgent"},
+	)
+
+	var got []string
+	for _, item := range ir.ActionItems() {
+		got = append(got, item.Title+" @ "+item.Where+" by "+item.Responsible)
+	}
+	want := []string{
+		"Repaint hallway @ Unit by ",
+		"Light is not working @ Big Meeting Room by Test",
+		"Chipped table top @ Pantry – Solid Wood long table by Ng",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ActionItems() = %q, want %q", got, want)
+	}
+
+	// The follow-up of the cases of the unit, rooms and items
+	html := renderSignoff(t, ir)
+	for _, row := range []string{"<tr><td>Due</td><td>2018-08-31</td></tr>", "<tr><td>Responsible</td><td>Management Company</td></tr>", "<tr><td>Responsible</td><td>Tenant</td></tr>"} {
+		if !strings.Contains(html, row) {
+			t.Errorf("signoff.html lacks %s", row)
+		}
+	}
+}
+
+func TestValidateAssignees(t *testing.T) {
+	ir := testReport(t)
+	ir.Report.Rooms[1].Inventory[1].Cases[0].Assignee = "Contractor"
+	ir.Report.Rooms[0].Cases[0].Due = "31/08/2018"
+	err := validateReport(ir)
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{
+		"report.rooms[0].cases[0].due: must be a valid date",
+		"report.rooms[1].inventory[1].cases[0].assignee: must be the role of a signature: Management Company, Tenant",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("validateReport() = %v, want %s", err, want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureAudit is the evidence of how a signature was given, for disputes
+type SignatureAudit struct {
+	SignedAt  time.Time `json:"signed_at"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Consent   string    `json:"consent,omitempty"`  // The statement shown to the signer
+	Location  *Location `json:"location,omitempty"` // When the signer agreed to share it
+}
+
+// When is SignedAt for people, in UTC
+func (a SignatureAudit) When() string {
+	return a.SignedAt.UTC().Format("2 Jan 2006 15:04:05 UTC")
+}
+
+// Location is where the browser of the signer was, as reported by it
+type Location struct {
+	Latitude  float64 `json:"latitude" jsonschema:"minimum=-90,maximum=90"`
+	Longitude float64 `json:"longitude" jsonschema:"minimum=-180,maximum=180"`
+	Accuracy  float64 `json:"accuracy,omitempty" jsonschema:"minimum=0"` // In metres
+}
+
+func (l Location) String() string {
+	s := strconv.FormatFloat(l.Latitude, 'f', 5, 64) + ", " + strconv.FormatFloat(l.Longitude, 'f', 5, 64)
+	if l.Accuracy > 0 {
+		s += fmt.Sprintf(" (±%.0f m)", l.Accuracy)
+	}
+	return s
+}
+
+// consentStatement is what a signer agrees to on the signing page
+func consentStatement(ir InspectionReport, s Signature) string {
+	who := s.Name
+	if s.Role != "" {
+		who += " (" + s.Role + ")"
+	}
+	return fmt.Sprintf("I, %s, have reviewed the inspection report %s of %s and agree with its content.",
+		who, ir.ID, ir.Report.Name)
+}
+
+// formConsent is what the signature pad form of handleIndex asks everyone to agree to
+func formConsent(ir InspectionReport) string {
+	return fmt.Sprintf("By signing, I confirm I have reviewed the inspection report %s of %s and agree with its content.",
+		ir.ID, ir.Report.Name)
+}
+
+// dropUnverifiedAudits clears the audits callers sent along with ir. Only
+// handleSign and handlePost record audits; the ones of a report we sealed,
+// such as a stored dump being regenerated, are kept as the seal proves them.
+func dropUnverifiedAudits(ir *InspectionReport) {
+	if ir.Seal != nil && sealKey != nil {
+		if _, ok, err := verifyReport(*ir); err == nil && ok {
+			return
+		}
+	}
+	for i := range ir.Signatures {
+		ir.Signatures[i].Audit = nil
+	}
+}
+
+// clientIP is the address of whoever sent r. Behind API Gateway it is the last
+// of X-Forwarded-For, the one the gateway added, as the client can send others.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		hops := strings.Split(fwd, ",")
+		return strings.TrimSpace(hops[len(hops)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// auditRequest records who sent r at now, having been shown consent
+func auditRequest(r *http.Request, now time.Time, consent string) *SignatureAudit {
+	return &SignatureAudit{
+		SignedAt:  now.UTC(),
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Consent:   consent,
+		Location:  formLocation(r),
+	}
+}
+
+// formLocation reads the optional latitude, longitude and accuracy fields of a form
+func formLocation(r *http.Request) *Location {
+	lat, err1 := strconv.ParseFloat(r.PostFormValue("latitude"), 64)
+	lng, err2 := strconv.ParseFloat(r.PostFormValue("longitude"), 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return nil
+	}
+	accuracy, _ := strconv.ParseFloat(r.PostFormValue("accuracy"), 64)
+	if accuracy < 0 {
+		accuracy = 0
+	}
+	return &Location{Latitude: lat, Longitude: lng, Accuracy: accuracy}
+}
+
+// AuditedSignatures are the signatures with a SignatureAudit, for the appendix
+func (ir InspectionReport) AuditedSignatures() (sigs []Signature) {
+	for _, s := range ir.Signatures {
+		if s.Audit != nil {
+			sigs = append(sigs, s)
+		}
+	}
+	return sigs
+}
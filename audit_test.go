@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	if got := clientIP(r); got != "192.0.2.1" {
+		t.Errorf("clientIP() = %q, want the remote address", got)
+	}
+	r.Header.Set("X-Forwarded-For", "10.0.0.1, 203.0.113.7")
+	if got := clientIP(r); got != "203.0.113.7" {
+		t.Errorf("clientIP() = %q, want the last forwarded address", got)
+	}
+}
+
+func TestFormLocation(t *testing.T) {
+	for _, tc := range []struct {
+		form neturl.Values
+		want string
+	}{
+		{neturl.Values{}, ""},
+		{neturl.Values{"latitude": {"1.3521"}}, ""},
+		{neturl.Values{"latitude": {"91"}, "longitude": {"0"}}, ""},
+		{neturl.Values{"latitude": {"1.3521"}, "longitude": {"103.8198"}}, "1.35210, 103.81980"},
+		{neturl.Values{"latitude": {"1.3521"}, "longitude": {"103.8198"}, "accuracy": {"20"}}, "1.35210, 103.81980 (±20 m)"},
+	} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tc.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		got := ""
+		if l := formLocation(r); l != nil {
+			got = l.String()
+		}
+		if got != tc.want {
+			t.Errorf("formLocation(%v) = %q, want %q", tc.form, got, tc.want)
+		}
+	}
+}
+
+func TestSignatureAudit(t *testing.T) {
+	ir := testReport(t)
+	signed := time.Date(2018, 8, 1, 9, 30, 0, 0, time.UTC)
+	ir.Signatures[1].Audit = &SignatureAudit{
+		SignedAt:  signed,
+		IP:        "203.0.113.7",
+		UserAgent: "Mozilla/5.0",
+		Consent:   consentStatement(ir, ir.Signatures[1]),
+		Location:  &Location{Latitude: 100, Longitude: 103.8198},
+	}
+	err := validateReport(ir)
+	if err == nil || !strings.Contains(err.Error(), "signatures[1].audit.location.latitude: must be at most 90") {
+		t.Errorf("validateReport() = %v, want the latitude out of range", err)
+	}
+
+	ir.Signatures[1].Audit.Location.Latitude = 1.3521
+	if err := validateReport(ir); err != nil {
+		t.Fatal(err)
+	}
+	if got := ir.AuditedSignatures(); len(got) != 1 || got[0].Name != "Ng" {
+		t.Errorf("AuditedSignatures() = %+v", got)
+	}
+	page := renderSignoff(t, ir)
+	for _, want := range []string{`id="signature-audit"`, "1 Aug 2018 09:30:00 UTC", "203.0.113.7", "I, Ng (Tenant), have reviewed"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("signoff.html lacks %q", want)
+		}
+	}
+}
+
+func TestForgedAuditsAreDropped(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+
+	ir := testReport(t)
+	ir.Signatures[1].Audit = &SignatureAudit{SignedAt: time.Date(2018, 8, 1, 9, 30, 0, 0, time.UTC), IP: "203.0.113.7"}
+	post := func(ir InspectionReport) InspectionReport {
+		body, _ := json.Marshal(ir)
+		w := httptest.NewRecorder()
+		handleJSON(w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST / = %d %s", w.Code, w.Body)
+		}
+		var output responseHTML
+		json.NewDecoder(w.Body).Decode(&output)
+		stored, _, err := findReport("", output.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+	if stored := post(ir); stored.Signatures[1].Audit != nil {
+		t.Errorf("forged audit kept: %+v", stored.Signatures[1].Audit)
+	}
+
+	// Recorded by us, as the seal shows, so kept when regenerating
+	sealed, err := genHTML(ir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump, _, err := findReport("", sealed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump.Force = true
+	if stored := post(dump); stored.Signatures[1].Audit == nil || stored.Signatures[1].Audit.IP != "203.0.113.7" {
+		t.Errorf("sealed audit dropped when regenerating")
+	}
+}
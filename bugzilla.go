@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BugzillaRef asks genHTML to import the open bugs of a Bugzilla product as cases
+type BugzillaRef struct {
+	Product   string `json:"product" jsonschema:"required"` // The Unit
+	Component string `json:"component,omitempty"`           // Only the bugs of this component
+	// RoomField is the bug field naming the room of a case, e.g. cf_room.
+	// Bugs without one, or when unset, are cases of the unit.
+	RoomField string `json:"room_field,omitempty"`
+	// CategoryField is the bug field used as Category, component when unset
+	CategoryField string `json:"category_field,omitempty"`
+}
+
+// Bugzilla reads bugs from the REST API of a Bugzilla 5 server
+type Bugzilla struct {
+	URL    string // BUGZILLA_URL, e.g. https://case.unee-t.com
+	APIKey string // BUGZILLA_API_KEY
+	Client *http.Client
+}
+
+// bugzilla is configured in main
+var bugzilla = Bugzilla{
+	URL:    os.Getenv("BUGZILLA_URL"),
+	Client: &http.Client{Timeout: 30 * time.Second},
+}
+
+// bug is a Bugzilla bug, keyed by field name as custom fields vary between installations
+type bug map[string]interface{}
+
+func (b bug) id() int {
+	n, _ := b["id"].(float64)
+	return int(n)
+}
+
+// field is the value of a text or single select field, empty when unset
+func (b bug) field(name string) string {
+	s, _ := b[name].(string)
+	if s == "---" {
+		return ""
+	}
+	return strings.TrimSpace(s)
+}
+
+// attachment is what Bugzilla returns about an attachment, without its data
+type attachment struct {
+	ID          int    `json:"id"`
+	ContentType string `json:"content_type"`
+	IsObsolete  int    `json:"is_obsolete"`
+	IsPrivate   int    `json:"is_private"`
+}
+
+// get decodes the reply of the REST API at path into v
+func (b Bugzilla) get(path string, query neturl.Values, v interface{}) error {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(b.URL, "/")+"/rest"+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if b.APIKey != "" {
+		req.Header.Set("X-Bugzilla-API-Key", b.APIKey)
+	}
+	res, err := b.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Errors are JSON too, with a 200 status for some of them
+	var reply struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}
+	dec := json.NewDecoder(res.Body)
+	if res.StatusCode != http.StatusOK {
+		dec.Decode(&reply)
+		if reply.Message == "" {
+			reply.Message = res.Status
+		}
+		return fmt.Errorf("GET %s: %s", path, reply.Message)
+	}
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return fmt.Errorf("GET %s: %v", path, err)
+	}
+	if json.Unmarshal(raw, &reply) == nil && reply.Error {
+		return fmt.Errorf("GET %s: %s", path, reply.Message)
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// openBugs are the unresolved bugs of ref, oldest first
+func (b Bugzilla) openBugs(ref BugzillaRef) ([]bug, error) {
+	fields := []string{"id", "summary", "status", "component"}
+	for _, f := range []string{ref.RoomField, ref.CategoryField} {
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	query := neturl.Values{
+		"product":        {ref.Product},
+		"resolution":     {"---"},
+		"include_fields": {strings.Join(fields, ",")},
+		"order":          {"bug_id"},
+	}
+	if ref.Component != "" {
+		query.Set("component", ref.Component)
+	}
+	var reply struct {
+		Bugs []bug `json:"bugs"`
+	}
+	err := b.get("/bug", query, &reply)
+	return reply.Bugs, err
+}
+
+// images are the links to the current, public image attachments of the bugs
+// ids, fetched at once
+func (b Bugzilla) images(ids []int) (urls map[int][]string, err error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var reply struct {
+		Bugs map[string][]attachment `json:"bugs"`
+	}
+	query := neturl.Values{"include_fields": {"id,content_type,is_obsolete,is_private"}}
+	for _, id := range ids[1:] {
+		query.Add("ids", strconv.Itoa(id))
+	}
+	if err := b.get("/bug/"+strconv.Itoa(ids[0])+"/attachment", query, &reply); err != nil {
+		return nil, err
+	}
+	urls = make(map[int][]string)
+	for _, id := range ids {
+		for _, a := range reply.Bugs[strconv.Itoa(id)] {
+			if a.IsObsolete != 0 || a.IsPrivate != 0 || !strings.HasPrefix(a.ContentType, "image/") {
+				continue
+			}
+			urls[id] = append(urls[id], fmt.Sprintf("%s/attachment.cgi?id=%d", strings.TrimSuffix(b.URL, "/"), a.ID))
+		}
+	}
+	return urls, nil
+}
+
+// bugStatuses are the statuses of Bugzilla 5 as the reports write them
+var bugStatuses = map[string]string{
+	"UNCONFIRMED": "Unconfirmed",
+	"CONFIRMED":   "Confirmed",
+	"IN_PROGRESS": "In Progress",
+	"RESOLVED":    "Resolved",
+	"VERIFIED":    "Verified",
+	// Of installations upgraded from Bugzilla 4
+	"NEW":      "New",
+	"ASSIGNED": "Assigned",
+	"REOPENED": "Reopened",
+	"CLOSED":   "Closed",
+}
+
+// bugStatus is a Bugzilla status as the reports write it, e.g. IN_PROGRESS as
+// In Progress. Statuses added by an installation keep their case.
+func bugStatus(s string) string {
+	if status, ok := bugStatuses[s]; ok {
+		return status
+	}
+	return strings.Replace(s, "_", " ", -1)
+}
+
+// importCases adds the open bugs of ir.Bugzilla to the cases of their room,
+// creating the rooms the report does not list yet. Bugs already imported, by an
+// earlier rendering of the same report, are skipped.
+func (b Bugzilla) importCases(ir *InspectionReport) error {
+	ref := ir.Bugzilla
+	if ref == nil {
+		return nil
+	}
+	if b.URL == "" {
+		return fmt.Errorf("no Bugzilla is configured, set BUGZILLA_URL")
+	}
+	bugs, err := b.openBugs(*ref)
+	if err != nil {
+		return err
+	}
+
+	imported := make(map[int]bool)
+	ir.Report.forEachCase(func(_, _ string, c Case) {
+		if c.Bug != 0 {
+			imported[c.Bug] = true
+		}
+	})
+	categoryField := ref.CategoryField
+	if categoryField == "" {
+		categoryField = "component"
+	}
+	var ids []int
+	for _, bg := range bugs {
+		if !imported[bg.id()] {
+			ids = append(ids, bg.id())
+		}
+	}
+	images, err := b.images(ids)
+	if err != nil {
+		return err
+	}
+	for _, bg := range bugs {
+		if imported[bg.id()] {
+			continue
+		}
+		c := Case{
+			Title:    bg.field("summary"),
+			Images:   images[bg.id()],
+			Category: bg.field(categoryField),
+			Status:   bugStatus(bg.field("status")),
+			Bug:      bg.id(),
+		}
+		room := ""
+		if ref.RoomField != "" {
+			room = bg.field(ref.RoomField)
+		}
+		ir.Report.addCase(room, c)
+	}
+	return nil
+}
+
+// addCase adds c to the room named room, the unit when empty
+func (r *Report) addCase(room string, c Case) {
+	if room == "" {
+		r.Cases = append(r.Cases, c)
+		return
+	}
+	for i := range r.Rooms {
+		if sameName(r.Rooms[i].Name, room) {
+			r.Rooms[i].Cases = append(r.Rooms[i].Cases, c)
+			return
+		}
+	}
+	r.Rooms = append(r.Rooms, Room{Name: room, Cases: []Case{c}})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/tj/go/http/response"
+)
+
+// fakeBugzilla serves the REST API calls of importCases for product "Unit 01-02"
+func fakeBugzilla(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/bug", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Bugzilla-API-Key") != "key" {
+			response.JSON(w, map[string]interface{}{"error": true, "code": 410, "message": "You must log in"}, http.StatusUnauthorized)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("product") != "Unit 01-02" {
+			response.JSON(w, map[string]interface{}{"error": true, "code": 51, "message": "There is no product named " + q.Get("product")})
+			return
+		}
+		if q.Get("resolution") != "---" {
+			t.Errorf("resolution = %q, want open bugs only", q.Get("resolution"))
+		}
+		response.JSON(w, map[string]interface{}{"bugs": []map[string]interface{}{
+			{"id": 11, "summary": "Leaking tap", "status": "CONFIRMED", "component": "Tenant", "cf_room": "Pantry"},
+			{"id": 12, "summary": "Broken window latch", "status": "IN_PROGRESS", "component": "Landlord", "cf_room": "Balcony"},
+			{"id": 13, "summary": "Intercom is dead", "status": "UNCONFIRMED", "component": "Management Company", "cf_room": "---"},
+			{"id": 14, "summary": "Already in the report", "status": "CONFIRMED", "component": "Tenant", "cf_room": "Pantry"},
+		}})
+	})
+	// The attachments of the bugs to import, fetched at once
+	mux.HandleFunc("/rest/bug/11/attachment", func(w http.ResponseWriter, r *http.Request) {
+		if ids := r.URL.Query()["ids"]; !reflect.DeepEqual(ids, []string{"12", "13"}) {
+			t.Errorf("ids = %v, want the other bugs to import", ids)
+		}
+		response.JSON(w, map[string]interface{}{"bugs": map[string]interface{}{
+			"11": []map[string]interface{}{
+				{"id": 101, "content_type": "image/jpeg", "is_obsolete": 0, "is_private": 0},
+				{"id": 102, "content_type": "image/jpeg", "is_obsolete": 1, "is_private": 0},
+				{"id": 103, "content_type": "application/pdf", "is_obsolete": 0, "is_private": 0},
+			},
+			"12": []map[string]interface{}{},
+			"13": []map[string]interface{}{},
+		}})
+	})
+	mux.HandleFunc("/rest/bug/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("GET %s, want the attachments of all bugs at once", r.URL.Path)
+		response.JSON(w, map[string]interface{}{"bugs": map[string]interface{}{}})
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestImportCases(t *testing.T) {
+	srv := fakeBugzilla(t)
+	defer srv.Close()
+	b := Bugzilla{URL: srv.URL, APIKey: "key", Client: srv.Client()}
+
+	ir := testReport(t)
+	ir.Report.Rooms[1].Cases = append(ir.Report.Rooms[1].Cases, Case{Title: "Already in the report", Bug: 14})
+	ir.Bugzilla = &BugzillaRef{Product: "Unit 01-02", RoomField: "cf_room"}
+	unitCases := len(ir.Report.Cases)
+	pantryCases := len(ir.Report.Rooms[1].Cases)
+
+	if err := b.importCases(&ir); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ir.Report.Cases[unitCases:]; !reflect.DeepEqual(got, []Case{
+		{Title: "Intercom is dead", Category: "Management Company", Status: "Unconfirmed", Bug: 13},
+	}) {
+		t.Errorf("unit cases = %+v", got)
+	}
+	if got := ir.Report.Rooms[1].Cases[pantryCases:]; !reflect.DeepEqual(got, []Case{
+		{Title: "Leaking tap", Images: []string{srv.URL + "/attachment.cgi?id=101"}, Category: "Tenant", Status: "Confirmed", Bug: 11},
+	}) {
+		t.Errorf("Pantry cases = %+v", got)
+	}
+	if n := len(ir.Report.Rooms); n != 3 || ir.Report.Rooms[2].Name != "Balcony" {
+		t.Fatalf("rooms = %d, want Balcony added", n)
+	}
+	if got := ir.Report.Rooms[2].Cases; !reflect.DeepEqual(got, []Case{
+		{Title: "Broken window latch", Category: "Landlord", Status: "In Progress", Bug: 12},
+	}) {
+		t.Errorf("Balcony cases = %+v", got)
+	}
+
+	// Rendering the same report again imports nothing more
+	before := ir.Report
+	if err := b.importCases(&ir); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ir.Report, before) {
+		t.Error("importing twice duplicated cases")
+	}
+}
+
+func TestImportCasesErrors(t *testing.T) {
+	srv := fakeBugzilla(t)
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		b       Bugzilla
+		product string
+		want    string
+	}{
+		{"unauthorised", Bugzilla{URL: srv.URL, Client: srv.Client()}, "Unit 01-02", "GET /bug: You must log in"},
+		{"error with 200 OK", Bugzilla{URL: srv.URL, APIKey: "key", Client: srv.Client()}, "Unit 99", "GET /bug: There is no product named Unit 99"},
+		{"not configured", Bugzilla{Client: srv.Client()}, "Unit 01-02", "no Bugzilla is configured, set BUGZILLA_URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ir := testReport(t)
+			ir.Bugzilla = &BugzillaRef{Product: tt.product}
+			err := tt.b.importCases(&ir)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("importCases() = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBugStatus(t *testing.T) {
+	for s, want := range map[string]string{
+		"IN_PROGRESS":        "In Progress",
+		"UNCONFIRMED":        "Unconfirmed",
+		"REOPENED":           "Reopened",
+		"WAITING_FOR_TENANT": "WAITING FOR TENANT",
+	} {
+		if got := bugStatus(s); got != want {
+			t.Errorf("bugStatus(%s) = %s, want %s", s, got, want)
+		}
+	}
+}
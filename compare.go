@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/tj/go/http/response"
+)
+
+// Comparison is what changed between a move-in and a move-out report
+type Comparison struct {
+	ID           string           `json:"id"`
+	Date         time.Time        `json:"date"`
+	Before       InspectionReport `json:"before"`
+	After        InspectionReport `json:"after"`
+	Unit         RoomComparison   `json:"unit"` // Cases and inventory of the report itself
+	Rooms        []RoomComparison `json:"rooms"`
+	RoomsAdded   []Room           `json:"rooms_added"`
+	RoomsRemoved []Room           `json:"rooms_removed"`
+}
+
+// RoomComparison is what changed in a room present in both reports
+type RoomComparison struct {
+	Name         string       `json:"name"`
+	Before       Room         `json:"before"`
+	After        Room         `json:"after"`
+	ItemsMissing []Item       `json:"items_missing"`
+	ItemsAdded   []Item       `json:"items_added"`
+	ItemsChanged []ItemChange `json:"items_changed"`
+	NewCases     []Case       `json:"new_cases"`
+}
+
+// ItemChange is an inventory item whose description, quantity or condition changed, or that has new cases
+type ItemChange struct {
+	Name     string `json:"name"`
+	Before   Item   `json:"before"`
+	After    Item   `json:"after"`
+	NewCases []Case `json:"new_cases"`
+}
+
+// Changed tells whether anything differs in the room
+func (rc RoomComparison) Changed() bool {
+	return len(rc.ItemsMissing) > 0 || len(rc.ItemsAdded) > 0 || len(rc.ItemsChanged) > 0 || len(rc.NewCases) > 0
+}
+
+type compareRequest struct {
+	Before   *InspectionReport `json:"before"`
+	After    *InspectionReport `json:"after"`
+	BeforeID string            `json:"before_id"` // Instead of before, a report generated earlier
+	AfterID  string            `json:"after_id"`
+}
+
+// sameName matches rooms and items regardless of case and spacing
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+func compareReports(before, after InspectionReport) Comparison {
+	c := Comparison{
+		Before: before,
+		After:  after,
+		Unit: compareRooms(
+			Room{Name: "Unit", Images: before.Report.Images, Cases: before.Report.Cases, Inventory: before.Report.Inventory},
+			Room{Name: "Unit", Images: after.Report.Images, Cases: after.Report.Cases, Inventory: after.Report.Inventory}),
+	}
+
+	matched := make([]bool, len(after.Report.Rooms))
+	for _, b := range before.Report.Rooms {
+		found := false
+		for i, a := range after.Report.Rooms {
+			if !matched[i] && sameName(a.Name, b.Name) {
+				matched[i], found = true, true
+				c.Rooms = append(c.Rooms, compareRooms(b, a))
+				break
+			}
+		}
+		if !found {
+			c.RoomsRemoved = append(c.RoomsRemoved, b)
+		}
+	}
+	for i, a := range after.Report.Rooms {
+		if !matched[i] {
+			c.RoomsAdded = append(c.RoomsAdded, a)
+		}
+	}
+	return c
+}
+
+func compareRooms(before, after Room) RoomComparison {
+	rc := RoomComparison{Name: after.Name, Before: before, After: after}
+
+	matched := make([]bool, len(after.Inventory))
+	for _, b := range before.Inventory {
+		found := false
+		for i, a := range after.Inventory {
+			if !matched[i] && sameName(a.Name, b.Name) {
+				matched[i], found = true, true
+				// As genHTML stores them, so a stored report matches the same items sent again
+				b.fromDescription()
+				a.fromDescription()
+				cases := newCases(b.Cases, a.Cases)
+				if strings.TrimSpace(a.Description) != strings.TrimSpace(b.Description) ||
+					a.Quantity != b.Quantity || a.Condition != b.Condition || len(cases) > 0 {
+					rc.ItemsChanged = append(rc.ItemsChanged, ItemChange{Name: a.Name, Before: b, After: a, NewCases: cases})
+				}
+				break
+			}
+		}
+		if !found {
+			rc.ItemsMissing = append(rc.ItemsMissing, b)
+		}
+	}
+	for i, a := range after.Inventory {
+		if !matched[i] {
+			rc.ItemsAdded = append(rc.ItemsAdded, a)
+		}
+	}
+
+	rc.NewCases = newCases(before.Cases, after.Cases)
+	return rc
+}
+
+// newCases are the cases of after without one of the same title in before
+func newCases(before, after []Case) (cases []Case) {
+	for _, a := range after {
+		found := false
+		for _, b := range before {
+			if sameName(a.Title, b.Title) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			cases = append(cases, a)
+		}
+	}
+	return cases
+}
+
+// genComparison renders and stores the comparison next to the move-out report
+func genComparison(c Comparison) (output responseHTML, err error) {
+	randomString, err := randomHex(4)
+	if err != nil {
+		return output, err
+	}
+	c.ID = fmt.Sprintf("%s-vs-%s-%s", c.Before.ID, c.After.ID, randomString)
+	c.Date = time.Now()
+
+	t, err := template.New("").Funcs(templateFuncs).ParseFiles("templates/compare.html")
+	if err != nil {
+		return output, err
+	}
+	var b bytes.Buffer
+	if err := t.ExecuteTemplate(&b, "compare.html", c); err != nil {
+		return output, err
+	}
+
+	dumpurl, _, err := dump(c.Date, c.ID, c)
+	if err != nil {
+		return output, err
+	}
+	htmlfilename := c.Date.Format("2006-01-02") + "/" + c.ID + ".html"
+	if err := store.Put(htmlfilename, b.Bytes(), "text/html; charset=UTF-8", Public); err != nil {
+		return output, err
+	}
+
+	return responseHTML{
+		ID:   c.ID,
+		HTML: store.URL(htmlfilename),
+		JSON: dumpurl,
+	}, nil
+}
+
+// reportFor is the inline report, else the stored report with id
+func reportFor(ir *InspectionReport, id string) (InspectionReport, error) {
+	if ir != nil {
+		return *ir, nil
+	}
+	if id == "" {
+		return InspectionReport{}, fmt.Errorf("a report or a report ID is required")
+	}
+	stored, _, err := findReport("", id)
+	if err == ErrNotFound {
+		return stored, fmt.Errorf("report %s not found", id)
+	}
+	return stored, err
+}
+
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	var req compareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, errNonConforming.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, err := reportFor(req.Before, req.BeforeID)
+	if err != nil {
+		http.Error(w, "before: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, err := reportFor(req.After, req.AfterID)
+	if err != nil {
+		http.Error(w, "after: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Infof("Comparing %s with %s", before.ID, after.ID)
+	output, err := genComparison(compareReports(before, after))
+	if err != nil {
+		log.WithError(err).Error("genComparison")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response.JSON(w, output)
+}
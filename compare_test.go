@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompareReports(t *testing.T) {
+	before, after := New(), New()
+	before.ID, after.ID = "movein", "moveout"
+
+	pantry := &after.Report.Rooms[1]
+	pantry.Inventory[1].Description = "1 in very bad condition. Table top is cracked."
+	pantry.Inventory = append(pantry.Inventory[:2], pantry.Inventory[3:]...) // Pantry cabinet is gone
+	pantry.Cases = append(pantry.Cases, Case{Title: "Fridge door broken", Status: "Confirmed"})
+	after.Report.Rooms = append(after.Report.Rooms[1:], Room{Name: "Balcony"})
+
+	c := compareReports(before, after)
+
+	if len(c.RoomsRemoved) != 1 || c.RoomsRemoved[0].Name != "Big Meeting Room" {
+		t.Errorf("RoomsRemoved = %+v", c.RoomsRemoved)
+	}
+	if len(c.RoomsAdded) != 1 || c.RoomsAdded[0].Name != "Balcony" {
+		t.Errorf("RoomsAdded = %+v", c.RoomsAdded)
+	}
+	if c.Unit.Changed() {
+		t.Errorf("Unit should be unchanged: %+v", c.Unit)
+	}
+	if len(c.Rooms) != 1 {
+		t.Fatalf("Rooms = %+v", c.Rooms)
+	}
+	rc := c.Rooms[0]
+	if len(rc.ItemsMissing) != 1 || rc.ItemsMissing[0].Name != "Pantry cabinet" {
+		t.Errorf("ItemsMissing = %+v", rc.ItemsMissing)
+	}
+	if len(rc.ItemsChanged) != 1 || rc.ItemsChanged[0].Name != "Solid Wood long table" {
+		t.Errorf("ItemsChanged = %+v", rc.ItemsChanged)
+	}
+	if len(rc.NewCases) != 1 || rc.NewCases[0].Title != "Fridge door broken" {
+		t.Errorf("NewCases = %+v", rc.NewCases)
+	}
+}
+
+func TestHandleCompareStoredReport(t *testing.T) {
+	mem := NewMemoryStorage("http://localhost/media")
+	store = mem
+	defer func() { store = nil }()
+
+	before := New()
+	before.ID, before.Force = "movein", true
+	if _, _, err := dump(before.Date, before.ID, before); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := json.Marshal(New())
+
+	w := httptest.NewRecorder()
+	handleCompare(w, httptest.NewRequest("POST", "/compare", strings.NewReader(`{"before_id":"movein","after":`+string(after)+`}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /compare = %d %s", w.Code, w.Body)
+	}
+	var output responseHTML
+	json.NewDecoder(w.Body).Decode(&output)
+	if !strings.HasPrefix(output.ID, "movein-vs-12345678-") {
+		t.Errorf("ID = %s", output.ID)
+	}
+	if _, err := mem.Get(strings.TrimPrefix(output.HTML, "http://localhost/media/")); err != nil {
+		t.Errorf("comparison not stored: %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	handleCompare(w, httptest.NewRequest("POST", "/compare", strings.NewReader(`{"before_id":"missing","after_id":"movein"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("POST /compare missing report = %d", w.Code)
+	}
+}
+
+func TestCompareItemCases(t *testing.T) {
+	before, after := New(), New()
+	chairs := &after.Report.Rooms[1].Inventory[3]
+	chairs.Cases = append(chairs.Cases, Case{Title: "Broken leg", Status: "Confirmed"})
+
+	rc := compareReports(before, after).Rooms[1]
+	if len(rc.ItemsChanged) != 1 || rc.ItemsChanged[0].Name != "Bekant chairs" {
+		t.Fatalf("ItemsChanged = %+v", rc.ItemsChanged)
+	}
+	if cases := rc.ItemsChanged[0].NewCases; len(cases) != 1 || cases[0].Title != "Broken leg" {
+		t.Errorf("NewCases = %+v", cases)
+	}
+	if len(rc.NewCases) != 0 {
+		t.Errorf("item cases reported as room cases: %+v", rc.NewCases)
+	}
+}
+
+func TestCompareStructuredInventory(t *testing.T) {
+	before, after := New(), New()
+	for _, ir := range []*InspectionReport{&before, &after} {
+		ir.Report.Rooms[0].Inventory = []Item{{Name: "Sofa", Description: "2 in good condition"}}
+	}
+	// As stored by genHTML
+	structureInventory(&before)
+
+	if rc := compareReports(before, after).Rooms[0]; len(rc.ItemsChanged) != 0 {
+		t.Errorf("ItemsChanged = %+v, want none", rc.ItemsChanged)
+	}
+}
+
+func TestComparisonShowsNewCasesOnce(t *testing.T) {
+	mem := NewMemoryStorage("http://localhost/media")
+	store = mem
+	defer func() { store = nil }()
+
+	before, after := New(), New()
+	pantry := &after.Report.Rooms[1]
+	pantry.Images = append(pantry.Images, "https://res.cloudinary.com/unee-t-staging/image/upload/fridge.jpg")
+	pantry.Cases = append(pantry.Cases, Case{Title: "Fridge door broken", Status: "Confirmed"})
+	output, err := genComparison(compareReports(before, after))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := mem.Get(strings.TrimPrefix(output.HTML, "http://localhost/media/"))
+	if n := strings.Count(string(page), "Fridge door broken"); n != 1 {
+		t.Errorf("new case shown %d times", n)
+	}
+}
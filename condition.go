@@ -0,0 +1,105 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Item conditions, best first
+const (
+	ConditionExcellent = "excellent"
+	ConditionGood      = "good"
+	ConditionFair      = "fair"
+	ConditionPoor      = "poor"
+	ConditionDamaged   = "damaged"
+)
+
+var conditions = []string{ConditionExcellent, ConditionGood, ConditionFair, ConditionPoor, ConditionDamaged}
+
+// legacyDescriptionRe reads descriptions such as "12 in mint condition" or
+// "1 in acceptable working condition. Well maintained."
+var legacyDescriptionRe = regexp.MustCompile(`(?i)^\s*(\d+)\s+in\s+([a-z ]+?)\s+(?:working\s+)?condition\b`)
+
+// legacyConditions are the words used in descriptions before Condition existed
+var legacyConditions = map[string]string{
+	"mint":       ConditionExcellent,
+	"excellent":  ConditionExcellent,
+	"new":        ConditionExcellent,
+	"good":       ConditionGood,
+	"acceptable": ConditionFair,
+	"fair":       ConditionFair,
+	"bad":        ConditionPoor,
+	"poor":       ConditionPoor,
+	"very bad":   ConditionDamaged,
+	"broken":     ConditionDamaged,
+	"damaged":    ConditionDamaged,
+}
+
+// fromDescription fills Quantity and Condition from a legacy Description, when unset
+func (i *Item) fromDescription() {
+	m := legacyDescriptionRe.FindStringSubmatch(i.Description)
+	if m == nil {
+		return
+	}
+	if i.Quantity == 0 {
+		i.Quantity, _ = strconv.Atoi(m[1])
+	}
+	if i.Condition == "" {
+		i.Condition = legacyConditions[strings.ToLower(m[2])]
+	}
+}
+
+// structureInventory fills the structured fields of legacy description-only items
+func structureInventory(ir *InspectionReport) {
+	for i := range ir.Report.Inventory {
+		ir.Report.Inventory[i].fromDescription()
+	}
+	for r := range ir.Report.Rooms {
+		for i := range ir.Report.Rooms[r].Inventory {
+			ir.Report.Rooms[r].Inventory[i].fromDescription()
+		}
+	}
+}
+
+// ConditionCount is how many items are in a condition
+type ConditionCount struct {
+	Condition string `json:"condition"` // Empty when not assessed
+	Quantity  int    `json:"quantity"`
+}
+
+// summariseConditions counts items per condition, best first, those not assessed last.
+// An item without a quantity counts as one.
+func summariseConditions(items []Item) (summary []ConditionCount) {
+	counts := make(map[string]int)
+	for _, item := range items {
+		n := item.Quantity
+		if n == 0 {
+			n = 1
+		}
+		counts[item.Condition] += n
+	}
+	for _, c := range append(conditions, "") {
+		if counts[c] > 0 {
+			summary = append(summary, ConditionCount{Condition: c, Quantity: counts[c]})
+		}
+	}
+	return summary
+}
+
+// conditionSummary is the summary as text, e.g. "12 excellent, 1 damaged"
+func conditionSummary(items []Item) string {
+	return describeConditions(summariseConditions(items))
+}
+
+func describeConditions(summary []ConditionCount) string {
+	var parts []string
+	for _, c := range summary {
+		condition := c.Condition
+		if condition == "" {
+			condition = "not assessed"
+		}
+		parts = append(parts, strconv.Itoa(c.Quantity)+" "+condition)
+	}
+	return strings.Join(parts, ", ")
+}
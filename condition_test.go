@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestItemFromDescription(t *testing.T) {
+	tests := []struct {
+		item          Item
+		wantQuantity  int
+		wantCondition string
+	}{
+		{Item{Description: "12 in mint condition."}, 12, ConditionExcellent},
+		{Item{Description: "1 in acceptable working condition"}, 1, ConditionFair},
+		{Item{Description: "1 in very bad condition. Table is baldy chipped."}, 1, ConditionDamaged},
+		{Item{Description: "1 in Good condition. Well maintained."}, 1, ConditionGood},
+		{Item{Description: "Oak, seats eight"}, 0, ""},
+		{Item{Description: "2 in mint condition", Quantity: 3, Condition: ConditionPoor}, 3, ConditionPoor},
+	}
+	for _, tt := range tests {
+		item := tt.item
+		item.fromDescription()
+		if item.Quantity != tt.wantQuantity || item.Condition != tt.wantCondition {
+			t.Errorf("%q = %d %q, want %d %q", tt.item.Description, item.Quantity, item.Condition, tt.wantQuantity, tt.wantCondition)
+		}
+	}
+}
+
+func TestSummariseConditions(t *testing.T) {
+	ir := New()
+	structureInventory(&ir)
+	pantry := ir.Report.Rooms[1].Inventory
+
+	want := []ConditionCount{{ConditionExcellent, 30}, {ConditionGood, 1}, {ConditionFair, 1}, {ConditionDamaged, 1}}
+	if got := summariseConditions(pantry); !reflect.DeepEqual(got, want) {
+		t.Errorf("summariseConditions() = %+v, want %+v", got, want)
+	}
+	if got := conditionSummary(append(pantry, Item{Name: "Plant"})); got != "30 excellent, 1 good, 1 fair, 1 damaged, 1 not assessed" {
+		t.Errorf("conditionSummary() = %q", got)
+	}
+}
+
+func TestItemConditionSchema(t *testing.T) {
+	ir := testReport(t)
+	ir.Report.Rooms[1].Inventory[0].Condition = "meh"
+	ir.Report.Rooms[1].Inventory[1].Quantity = -1
+	err := validateReport(ir)
+	if err == nil || !strings.Contains(err.Error(), "report.rooms[1].inventory[0].condition: must be one of excellent, good, fair, poor, damaged") ||
+		!strings.Contains(err.Error(), "report.rooms[1].inventory[1].quantity: must be at least 0") {
+		t.Errorf("validateReport() = %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "strings"
+
+// creatorIndex is the index of the signature of whoever created ir: the one
+// with the email of Report.Creator, or the first when the report has no
+// Creator, as reports had before. -1 when there is none.
+func (ir InspectionReport) creatorIndex() int {
+	if ir.Report.Creator == "" {
+		if len(ir.Signatures) == 0 {
+			return -1
+		}
+		return 0
+	}
+	for i, s := range ir.Signatures {
+		if sameEmail(s.Email, ir.Report.Creator) {
+			return i
+		}
+	}
+	return -1
+}
+
+// CreatedBy is the signature of the creator, in a slice to range over
+func (ir InspectionReport) CreatedBy() []Signature {
+	i := ir.creatorIndex()
+	if i < 0 {
+		return nil
+	}
+	return ir.Signatures[i : i+1]
+}
+
+// Involved are the signatures of everyone but the creator, in their order
+func (ir InspectionReport) Involved() (sigs []Signature) {
+	creator := ir.creatorIndex()
+	for i, s := range ir.Signatures {
+		if i != creator {
+			sigs = append(sigs, s)
+		}
+	}
+	return sigs
+}
+
+// sameEmail compares addresses regardless of case and surrounding spaces
+func sameEmail(a, b string) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	return a != "" && strings.EqualFold(a, b)
+}
+
+// validateCreator requires Report.Creator to be the email of a signature
+func validateCreator(ir InspectionReport, v *ValidationErrors) {
+	if ir.Report.Creator == "" || len(ir.Signatures) == 0 || ir.creatorIndex() >= 0 {
+		return
+	}
+	var emails []string
+	for _, s := range ir.Signatures {
+		if s.Email != "" {
+			emails = append(emails, s.Email)
+		}
+	}
+	if len(emails) == 0 {
+		v.add("report.creator", "must be the email of a signature, none has one")
+		return
+	}
+	v.add("report.creator", "must be the email of a signature: %s", strings.Join(emails, ", "))
+}
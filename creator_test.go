@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCreator(t *testing.T) {
+	ir := testReport(t)
+	names := func(sigs []Signature) (n []string) {
+		for _, s := range sigs {
+			n = append(n, s.Name)
+		}
+		return n
+	}
+	if got := names(ir.CreatedBy()); !reflect.DeepEqual(got, []string{"Test"}) {
+		t.Errorf("CreatedBy() without creator = %q, want the first signature", got)
+	}
+
+	ir.Signatures[0].Email = "test@example.com"
+	ir.Signatures[1].Email = "ng@example.com"
+	ir.Report.Creator = " NG@example.com"
+	if got := names(ir.CreatedBy()); !reflect.DeepEqual(got, []string{"Ng"}) {
+		t.Errorf("CreatedBy() = %q, want Ng", got)
+	}
+	if got := names(ir.Involved()); !reflect.DeepEqual(got, []string{"Test"}) {
+		t.Errorf("Involved() = %q, want Test", got)
+	}
+	ir.Report.Creator = "ng@example.com"
+	if err := validateReport(ir); err != nil {
+		t.Errorf("validateReport() = %v", err)
+	}
+
+	ir.Report.Creator = "kai@example.com"
+	if len(ir.CreatedBy()) != 0 || len(ir.Involved()) != 2 {
+		t.Errorf("unmatched creator: created by %q, involved %q", names(ir.CreatedBy()), names(ir.Involved()))
+	}
+	err := validateReport(ir)
+	if err == nil || err.Error() != "report.creator: must be the email of a signature: test@example.com, ng@example.com" {
+		t.Errorf("validateReport() = %v", err)
+	}
+	ir.Signatures[0].Email, ir.Signatures[1].Email = "", ""
+	if err := validateReport(ir); err == nil || !strings.HasSuffix(err.Error(), "none has one") {
+		t.Errorf("validateReport() = %v", err)
+	}
+}
+
+func TestCreatorRendered(t *testing.T) {
+	ir := testReport(t)
+	ir.Signatures[1].Email = "ng@example.com"
+	ir.Report.Creator = "ng@example.com"
+	html := renderSignoff(t, ir)
+	created := html[strings.Index(html, "Report created by"):strings.Index(html, "People involved")]
+	if !strings.Contains(created, "<strong>Ng</strong>") || strings.Contains(created, "<strong>Test</strong>") {
+		t.Errorf("created by section = %s", created)
+	}
+}
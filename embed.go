@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	_ "image/gif" // Decoders for what Cloudinary serves, with jpeg and png in pdf.go
+	"image/jpeg"
+	"image/png"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// Render modes of a report, how its images are included
+const (
+	RenderLink  = "link"  // Remote URLs, the default
+	RenderEmbed = "embed" // Resized and inlined as data URIs
+	RenderCopy  = "copy"  // Resized and stored alongside the HTML
+)
+
+// Embedder makes a rendered report independent of the hosts of its images.
+// Only <img> sources are replaced, links to the full size images are left as they are.
+type Embedder struct {
+	Fetcher      *Fetcher
+	MaxDimension int // Longest side after resizing, in pixels
+	MaxPixels    int // Images larger than this are not decoded
+	MaxTotal     int // Bytes a report may inline, images beyond stay linked
+	Concurrency  int // Images downloaded at once
+}
+
+// imageEmbedder fetches from IMAGE_HOSTS, a comma separated list
+var imageEmbedder = &Embedder{
+	Fetcher: &Fetcher{
+		AllowedHosts: imageHosts(),
+		Timeout:      20 * time.Second,
+		MaxBytes:     10 << 20,
+		NoCache:      true,
+	},
+	MaxDimension: 1000,
+	MaxPixels:    40e6,
+	MaxTotal:     25 << 20,
+	Concurrency:  4,
+}
+
+func imageHosts() []string {
+	hosts := os.Getenv("IMAGE_HOSTS")
+	if hosts == "" {
+		return []string{"res.cloudinary.com", ".unee-t.com"}
+	}
+	return strings.Split(hosts, ",")
+}
+
+// imgSrcRe finds the remote source of an image in HTML written by html/template
+var imgSrcRe = regexp.MustCompile(`(<img\b[^>]*?\ssrc=")(https?://[^"]+)(")`)
+
+// embeddedImage is an image ready to be inlined or copied
+type embeddedImage struct {
+	contentType string
+	data        []byte
+}
+
+// Embed replaces the remote images of page according to mode. Copies are
+// stored under dir, e.g. "2018-08-20/12345678-abcd/". An image that cannot
+// be fetched or decoded stays linked rather than failing the report.
+func (em *Embedder) Embed(page []byte, mode, dir string) ([]byte, error) {
+	if mode != RenderEmbed && mode != RenderCopy {
+		return page, nil
+	}
+
+	// Distinct sources in document order, so the MaxTotal budget is predictable
+	var sources []string
+	seen := make(map[string]bool)
+	for _, m := range imgSrcRe.FindAllSubmatch(page, -1) {
+		src := html.UnescapeString(string(m[2]))
+		if !seen[src] {
+			seen[src] = true
+			sources = append(sources, src)
+		}
+	}
+
+	images := make([]*embeddedImage, len(sources))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, em.Concurrency)
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			img, err := em.fetch(src)
+			if err != nil {
+				log.WithError(err).Warnf("keeping image %s linked", src)
+				return
+			}
+			images[i] = img
+		}(i, src)
+	}
+	wg.Wait()
+
+	replace := make(map[string]string)
+	total := 0
+	for i, img := range images {
+		if img == nil {
+			continue
+		}
+		switch mode {
+		case RenderEmbed:
+			uri := "data:" + img.contentType + ";base64," + base64.StdEncoding.EncodeToString(img.data)
+			if total+len(uri) > em.MaxTotal {
+				log.Warnf("keeping image %s linked, the report would embed more than %d bytes", sources[i], em.MaxTotal)
+				continue
+			}
+			total += len(uri)
+			replace[sources[i]] = uri
+		case RenderCopy:
+			sum := sha256.Sum256([]byte(sources[i]))
+			key := dir + hex.EncodeToString(sum[:8]) + imageExtension(img.contentType)
+			if err := store.Put(key, img.data, img.contentType, Public); err != nil {
+				return nil, fmt.Errorf("copying image %s: %v", sources[i], err)
+			}
+			replace[sources[i]] = store.URL(key)
+		}
+	}
+
+	return imgSrcRe.ReplaceAllFunc(page, func(m []byte) []byte {
+		parts := imgSrcRe.FindSubmatch(m)
+		to, ok := replace[html.UnescapeString(string(parts[2]))]
+		if !ok {
+			return m
+		}
+		return []byte(string(parts[1]) + html.EscapeString(to) + string(parts[3]))
+	}), nil
+}
+
+// fetch downloads src and shrinks it to fit MaxDimension. Images already small
+// enough keep their original encoding.
+func (em *Embedder) fetch(src string) (*embeddedImage, error) {
+	body, err := em.Fetcher.Fetch(src)
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", src, err)
+	}
+	if cfg.Width*cfg.Height > em.MaxPixels {
+		return nil, fmt.Errorf("%s: %dx%d is larger than %d pixels", src, cfg.Width, cfg.Height, em.MaxPixels)
+	}
+	if cfg.Width <= em.MaxDimension && cfg.Height <= em.MaxDimension {
+		return &embeddedImage{contentType: "image/" + format, data: body}, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", src, err)
+	}
+	return encodeImage(fitWithin(img, em.MaxDimension))
+}
+
+// encodeImage writes a resized image as JPEG, or PNG when it has transparency
+func encodeImage(img *image.RGBA) (*embeddedImage, error) {
+	var b bytes.Buffer
+	if img.Opaque() {
+		err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 85})
+		return &embeddedImage{contentType: "image/jpeg", data: b.Bytes()}, err
+	}
+	err := png.Encode(&b, img)
+	return &embeddedImage{contentType: "image/png", data: b.Bytes()}, err
+}
+
+func imageExtension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	}
+	return ""
+}
+
+// fitWithin scales img down so its longest side is max pixels
+func fitWithin(img image.Image, max int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w >= h {
+		w, h = max, (h*max+w/2)/w
+	} else {
+		w, h = (w*max+h/2)/h, max
+	}
+	return resample(img, b, w, h)
+}
+
+// fillTo scales img to cover w x h, cropping the overflow around the centre
+func fillTo(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	crop := b
+	if b.Dx()*h > b.Dy()*w {
+		cw := b.Dy() * w / h
+		crop.Min.X += (b.Dx() - cw) / 2
+		crop.Max.X = crop.Min.X + cw
+	} else {
+		ch := b.Dx() * h / w
+		crop.Min.Y += (b.Dy() - ch) / 2
+		crop.Max.Y = crop.Min.Y + ch
+	}
+	return resample(img, crop, w, h)
+}
+
+// resample scales the area src of img to w x h, averaging the source pixels
+// covered by each destination pixel, or taking the nearest when enlarging
+func resample(img image.Image, src image.Rectangle, w, h int) *image.RGBA {
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := src.Min.Y + y*src.Dy()/h
+		y1 := src.Min.Y + (y+1)*src.Dy()/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0 := src.Min.X + x*src.Dx()/w
+			x1 := src.Min.X + (x+1)*src.Dx()/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func testImage(t *testing.T, w, h int, encode func(*bytes.Buffer, image.Image) error) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	var b bytes.Buffer
+	if err := encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func testEmbedder(t *testing.T) (*Embedder, *httptest.Server) {
+	large := testImage(t, 1200, 600, func(b *bytes.Buffer, img image.Image) error { return png.Encode(b, img) })
+	small := testImage(t, 40, 30, func(b *bytes.Buffer, img image.Image) error { return jpeg.Encode(b, img, nil) })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large.png":
+			w.Write(large)
+		case "/small.jpg":
+			w.Write(small)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	u, _ := neturl.Parse(srv.URL)
+	em := &Embedder{
+		Fetcher:      &Fetcher{AllowedHosts: []string{u.Hostname()}, AllowPrivate: true, MaxBytes: 1 << 20, NoCache: true},
+		MaxDimension: 1000,
+		MaxPixels:    4e6,
+		MaxTotal:     1 << 20,
+		Concurrency:  2,
+	}
+	return em, srv
+}
+
+var srcRe = regexp.MustCompile(`src="([^"]*)"`)
+
+func sources(page []byte) (srcs []string) {
+	for _, m := range srcRe.FindAllSubmatch(page, -1) {
+		srcs = append(srcs, string(m[1]))
+	}
+	return srcs
+}
+
+func TestEmbedImages(t *testing.T) {
+	em, srv := testEmbedder(t)
+	defer srv.Close()
+	page := []byte(`<img alt="" src="` + srv.URL + `/large.png?a=1&amp;b=2"><p>text</p>` +
+		`<img alt="" src="` + srv.URL + `/small.jpg"><img src="` + srv.URL + `/missing.jpg">` +
+		`<img alt="" src="data:image/png;base64,AAAA"><a href="` + srv.URL + `/large.png">full size</a>`)
+
+	got, err := em.Embed(page, RenderEmbed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcs := sources(got)
+	if len(srcs) != 4 {
+		t.Fatalf("sources = %q", srcs)
+	}
+
+	if !strings.HasPrefix(srcs[0], "data:image/jpeg;base64,") {
+		t.Fatalf("large image = %.40s, want it resized as JPEG", srcs[0])
+	}
+	data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(srcs[0], "data:image/jpeg;base64,"))
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width != 1000 || cfg.Height != 500 {
+		t.Errorf("large image is %dx%d, %v, want 1000x500", cfg.Width, cfg.Height, err)
+	}
+
+	if !strings.HasPrefix(srcs[1], "data:image/jpeg;base64,") {
+		t.Errorf("small image = %.40s, want it inlined", srcs[1])
+	}
+	if srcs[2] != srv.URL+"/missing.jpg" {
+		t.Errorf("missing image = %s, want it left linked", srcs[2])
+	}
+	if !bytes.Contains(got, []byte(`<a href="`+srv.URL+`/large.png">`)) {
+		t.Error("link to the full size image was changed")
+	}
+
+	// Beyond the budget, images stay linked
+	em.MaxTotal = len(srcs[0]) + 10
+	got, err = em.Embed(page, RenderEmbed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srcs := sources(got); srcs[1] != srv.URL+"/small.jpg" {
+		t.Errorf("small image = %.40s, want it linked once the budget is spent", srcs[1])
+	}
+
+	// Images too large to decode safely stay linked
+	em.MaxTotal, em.MaxPixels = 1<<20, 500*500
+	got, _ = em.Embed(page, RenderEmbed, "")
+	if srcs := sources(got); !strings.HasPrefix(srcs[0], srv.URL) {
+		t.Errorf("large image = %.40s, want it linked", srcs[0])
+	}
+}
+
+func TestCopyImages(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+	em, srv := testEmbedder(t)
+	defer srv.Close()
+
+	page := []byte(`<img alt="" src="` + srv.URL + `/small.jpg"><img alt="" src="` + srv.URL + `/small.jpg">`)
+	got, err := em.Embed(page, RenderCopy, "2018-08-20/12345678-abcd/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcs := sources(got)
+	if len(srcs) != 2 || srcs[0] != srcs[1] || !strings.HasPrefix(srcs[0], "http://localhost/media/2018-08-20/12345678-abcd/") {
+		t.Fatalf("sources = %q, want one copy alongside the HTML", srcs)
+	}
+	keys, _ := store.List("2018-08-20/12345678-abcd/")
+	if len(keys) != 1 || !strings.HasSuffix(keys[0], ".jpg") {
+		t.Errorf("stored %q", keys)
+	}
+
+	if got, _ := em.Embed(page, RenderLink, ""); !bytes.Equal(got, page) {
+		t.Error("link mode changed the page")
+	}
+}
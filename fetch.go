@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Fetcher gets remote templates from inside our AWS account without exposing
+// internal endpoints: only allowed hosts, only public addresses, bounded in
+// time and size, and cached by ETag
+type Fetcher struct {
+	// AllowedHosts are exact hosts, or suffixes when starting with a dot
+	AllowedHosts []string
+	Timeout      time.Duration
+	MaxBytes     int64
+	// AllowPrivate permits loopback and private addresses, for tests only
+	AllowPrivate bool
+	// NoCache skips the ETag cache, for bodies fetched once such as images
+	NoCache bool
+
+	once   sync.Once
+	client *http.Client
+	mu     sync.Mutex
+	cache  map[string]fetched
+}
+
+type fetched struct {
+	etag string
+	body []byte
+}
+
+var errBlockedAddress = errors.New("address is not public")
+
+// templateFetcher is configured from TEMPLATE_HOSTS, a comma separated list
+var templateFetcher = &Fetcher{
+	AllowedHosts: templateHosts(),
+	Timeout:      10 * time.Second,
+	MaxBytes:     1 << 20,
+}
+
+func templateHosts() []string {
+	hosts := os.Getenv("TEMPLATE_HOSTS")
+	if hosts == "" {
+		return []string{".unee-t.com"}
+	}
+	return strings.Split(hosts, ",")
+}
+
+// blockedIP is true for addresses inside our network or the instance metadata service
+func blockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	// Carrier-grade NAT, used by some VPC setups
+	_, cgnat, _ := net.ParseCIDR("100.64.0.0/10")
+	return cgnat.Contains(ip)
+}
+
+func (f *Fetcher) allowedHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range f.AllowedHosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == host || (strings.HasPrefix(h, ".") && strings.HasSuffix(host, h)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fetcher) checkURL(u *neturl.URL) error {
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("%s: only http and https are allowed", u)
+	}
+	if !f.allowedHost(u.Hostname()) {
+		return fmt.Errorf("%s: host %s is not allowed", u, u.Hostname())
+	}
+	if port := u.Port(); port != "" && port != "80" && port != "443" && !f.AllowPrivate {
+		return fmt.Errorf("%s: port %s is not allowed", u, port)
+	}
+	return nil
+}
+
+func (f *Fetcher) init() {
+	dialer := &net.Dialer{
+		Timeout: f.Timeout,
+		// Checked after DNS resolution, so a public name pointing inside is refused too
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || (!f.AllowPrivate && blockedIP(ip)) {
+				return fmt.Errorf("%s: %v", address, errBlockedAddress)
+			}
+			return nil
+		},
+	}
+	f.client = &http.Client{
+		Timeout: f.Timeout,
+		Transport: &http.Transport{
+			Proxy: nil,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			TLSHandshakeTimeout:   f.Timeout,
+			ResponseHeaderTimeout: f.Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			return f.checkURL(req.URL)
+		},
+	}
+	f.cache = make(map[string]fetched)
+}
+
+// Check tells whether url may be requested, before any DNS lookup
+func (f *Fetcher) Check(url string) error {
+	u, err := neturl.ParseRequestURI(url)
+	if err != nil {
+		return err
+	}
+	return f.checkURL(u)
+}
+
+// Do sends req with the same restrictions as Fetch, without caching
+func (f *Fetcher) Do(req *http.Request) (*http.Response, error) {
+	f.once.Do(f.init)
+	if err := f.checkURL(req.URL); err != nil {
+		return nil, err
+	}
+	return f.client.Do(req)
+}
+
+// Fetch returns the body at url, revalidating a cached copy with If-None-Match
+func (f *Fetcher) Fetch(url string) ([]byte, error) {
+	f.once.Do(f.init)
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	cached, ok := f.cache[url]
+	f.mu.Unlock()
+	if ok {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && ok:
+		return cached.body, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	case resp.ContentLength > f.MaxBytes:
+		return nil, fmt.Errorf("%s: larger than %d bytes", url, f.MaxBytes)
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, f.MaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", url, err)
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" && !f.NoCache {
+		f.mu.Lock()
+		f.cache[url] = fetched{etag: etag, body: body}
+		f.mu.Unlock()
+	}
+	return body, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchBlocksPrivateAddresses(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback server")
+	}))
+	defer ts.Close()
+
+	// Use the client directly, as Fetch would refuse the test server port first
+	f := &Fetcher{AllowedHosts: []string{"127.0.0.1"}, Timeout: time.Second, MaxBytes: 1 << 10}
+	f.once.Do(f.init)
+	_, err := f.client.Get(ts.URL)
+	if err == nil || !strings.Contains(err.Error(), errBlockedAddress.Error()) {
+		t.Fatalf("expected blocked address, got %v", err)
+	}
+
+	for _, ip := range []string{"10.0.0.1", "172.16.0.1", "192.168.1.1", "169.254.169.254", "127.0.0.1", "::1", "fe80::1", "100.64.0.1"} {
+		if !blockedIP(net.ParseIP(ip)) {
+			t.Errorf("%s should be blocked", ip)
+		}
+	}
+	if blockedIP(net.ParseIP("52.74.0.1")) {
+		t.Error("public address should not be blocked")
+	}
+}
+
+func TestFetchAllowlist(t *testing.T) {
+	f := &Fetcher{AllowedHosts: []string{".unee-t.com", "example.org"}, Timeout: time.Second, MaxBytes: 1 << 10}
+	for _, url := range []string{
+		"http://169.254.169.254/latest/meta-data/",
+		"https://unee-t.com.evil.io/t.html",
+		"https://sub.example.org/t.html",
+		"file:///etc/passwd",
+		"https://media.unee-t.com:8080/t.html",
+	} {
+		if _, err := f.Fetch(url); err == nil {
+			t.Errorf("%s should be refused", url)
+		}
+	}
+	if !f.allowedHost("media.unee-t.com") || !f.allowedHost("example.org") {
+		t.Error("allowed hosts were refused")
+	}
+}
+
+func TestFetchETagAndSize(t *testing.T) {
+	hits, revalidated := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/big" {
+			w.Write([]byte(strings.Repeat("x", 2<<10)))
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidated++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("<p>{{ .ID }}</p>"))
+	}))
+	defer ts.Close()
+
+	f := &Fetcher{AllowedHosts: []string{"127.0.0.1"}, Timeout: time.Second, MaxBytes: 1 << 10, AllowPrivate: true}
+	for i := 0; i < 2; i++ {
+		body, err := f.Fetch(ts.URL + "/t.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "<p>{{ .ID }}</p>" {
+			t.Fatalf("unexpected body %q", body)
+		}
+	}
+	if hits != 2 || revalidated != 1 {
+		t.Errorf("expected one revalidation, got %d hits and %d revalidations", hits, revalidated)
+	}
+
+	if _, err := f.Fetch(ts.URL + "/big"); err == nil {
+		t.Error("expected body over MaxBytes to be refused")
+	}
+}
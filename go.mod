@@ -1,33 +1,34 @@
 module github.com/unee-t/inspectionreport
 
-go 1.12
+go 1.18
 
 require (
 	github.com/apex/log v1.1.1
-	github.com/aphistic/sweet v0.3.0 // indirect
-	github.com/aws/aws-sdk-go v1.23.12 // indirect
 	github.com/aws/aws-sdk-go-v2 v0.11.0
-	github.com/go-sql-driver/mysql v1.4.1 // indirect
-	github.com/golang/protobuf v1.3.2 // indirect
-	github.com/gorilla/context v1.1.1 // indirect
 	github.com/gorilla/csrf v1.6.1
 	github.com/gorilla/mux v1.7.3
 	github.com/gorilla/schema v1.1.0
-	github.com/mattn/go-isatty v0.0.9 // indirect
-	github.com/onsi/ginkgo v1.10.0 // indirect
-	github.com/onsi/gomega v1.7.0 // indirect
-	github.com/rogpeppe/fastuuid v1.2.0 // indirect
-	github.com/smartystreets/gunit v1.0.2 // indirect
-	github.com/stretchr/objx v0.2.0 // indirect
-	github.com/stretchr/testify v1.4.0 // indirect
 	github.com/tj/go v1.8.6
 	github.com/unee-t/env v0.0.0-20190513035325-a55bf10999d5
-	golang.org/x/crypto v0.0.0-20190829043050-9756ffdc2472 // indirect
-	golang.org/x/net v0.0.0-20190827160401-ba9fcec4b297 // indirect
-	golang.org/x/sys v0.0.0-20190830023255-19e00faab6ad // indirect
-	golang.org/x/tools v0.0.0-20190829210313-340205e581e5 // indirect
-	google.golang.org/appengine v1.6.2 // indirect
-	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
+	go.mongodb.org/mongo-driver v1.17.6
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+	github.com/gorilla/securecookie v1.1.1 // indirect
+	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
+	github.com/klauspost/compress v1.16.7 // indirect
+	github.com/montanaflynn/stats v0.7.1 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/stretchr/testify v1.4.0 // indirect
+	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
+	github.com/xdg-go/scram v1.1.2 // indirect
+	github.com/xdg-go/stringprep v1.0.4 // indirect
+	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78 // indirect
+	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
 )
 
 replace github.com/aws/aws-sdk-go-v2 => github.com/aws/aws-sdk-go-v2 v0.7.0
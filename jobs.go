@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"github.com/tj/go/http/response"
+)
+
+// Job states, as reported by GET /jobs/{id}
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// How long finished jobs can be polled for
+const jobTTL = 24 * time.Hour
+
+// jobLease is how long a running job may go without an update before it is
+// taken to be lost with the instance running it, and rendered again
+const jobLease = 5 * time.Minute
+
+// Job is a report being rendered in the background, stored under jobs/
+type Job struct {
+	ID       string        `json:"id"`
+	ReportID string        `json:"report_id"`
+	Status   string        `json:"status"`
+	Output   *responseHTML `json:"output,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Created  time.Time     `json:"created"`
+	Updated  time.Time     `json:"updated"`
+}
+
+// jobRecord is a Job as stored, with what it renders until it finished
+type jobRecord struct {
+	Job
+	Report   *InspectionReport `json:"report,omitempty"`
+	Warnings []FieldError      `json:"warnings,omitempty"` // Of prepareReport, added to the output
+
+	// The draft whose signed report it renders, queued at DraftQueued
+	Draft       string    `json:"draft,omitempty"`
+	DraftQueued time.Time `json:"draft_queued,omitempty"`
+}
+
+// runnable tells whether the job must be rendered at now: it is queued, or its
+// instance was lost while it was running
+func (rec jobRecord) runnable(now time.Time) bool {
+	switch rec.Status {
+	case JobQueued:
+		return true
+	case JobRunning:
+		return now.Sub(rec.Updated) > jobLease
+	}
+	return false
+}
+
+func jobKey(id string) string {
+	return "jobs/" + id + ".json"
+}
+
+var jobIDRe = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+func loadJob(id string) (rec jobRecord, version string, err error) {
+	if !jobIDRe.MatchString(id) {
+		return rec, "", ErrNotFound
+	}
+	body, version, err := store.GetVersion(jobKey(id))
+	if err != nil {
+		return rec, "", err
+	}
+	err = json.Unmarshal(body, &rec)
+	return rec, version, err
+}
+
+// putJob writes rec if its stored version is still version, see Storage.PutIf
+func putJob(rec jobRecord, version string) error {
+	body, err := json.MarshalIndent(rec, "", "    ")
+	if err != nil {
+		return err
+	}
+	return store.PutIf(jobKey(rec.ID), body, "application/json; charset=UTF-8", Private, version)
+}
+
+// saveJob writes rec whatever its stored version
+func saveJob(rec jobRecord) error {
+	body, err := json.MarshalIndent(rec, "", "    ")
+	if err != nil {
+		return err
+	}
+	return store.Put(jobKey(rec.ID), body, "application/json; charset=UTF-8", Private)
+}
+
+// jobQueue renders the stored jobs with render. Jobs are stored before they
+// are handed to the workers of this instance, so that any instance can run a
+// job which was not, see Run.
+type jobQueue struct {
+	queue  chan string
+	render func(InspectionReport) (responseHTML, error)
+
+	// done is called once a job has succeeded or failed
+	done func(ir InspectionReport, job Job, output responseHTML, err error)
+}
+
+// jobs is the queue used by the /jobs handlers
+var jobs *jobQueue
+
+// newJobQueue starts workers goroutines, holding up to size pending jobs
+func newJobQueue(workers, size int, render func(InspectionReport) (responseHTML, error)) *jobQueue {
+	q := &jobQueue{
+		queue:  make(chan string, size),
+		render: render,
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Submit stores a job rendering ir, prepared with warnings, returning it queued
+func (q *jobQueue) Submit(ir InspectionReport, warnings []FieldError) (Job, error) {
+	return q.submit(jobRecord{Report: &ir, Warnings: warnings})
+}
+
+func (q *jobQueue) submit(rec jobRecord) (Job, error) {
+	job, err := newJob(rec)
+	if err != nil {
+		return job, err
+	}
+	select {
+	case q.queue <- job.ID:
+	default:
+		// Rendered by Run when polled, or once the workers catch up with Resume
+		log.Warnf("Render queue is full, job %s waits to be polled", job.ID)
+	}
+	return job, nil
+}
+
+// newJob stores rec as a queued job, for Run to render
+func newJob(rec jobRecord) (Job, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return Job{}, err
+	}
+	now := time.Now()
+	rec.Job = Job{ID: id, ReportID: rec.Report.ID, Status: JobQueued, Created: now, Updated: now}
+	if err := putJob(rec, ""); err != nil {
+		return Job{}, err
+	}
+	return rec.Job, nil
+}
+
+// Get returns the stored job
+func (q *jobQueue) Get(id string) (Job, error) {
+	rec, _, err := loadJob(id)
+	return rec.Job, err
+}
+
+// Run renders the job id when it is queued or was lost while running,
+// returning it as it stands. Running it is claimed by a conditional write, so
+// only one of the workers and requests asking at once renders it.
+func (q *jobQueue) Run(id string) (Job, error) {
+	rec, version, err := loadJob(id)
+	if err != nil || !rec.runnable(time.Now()) {
+		return rec.Job, err
+	}
+	rec.Status, rec.Updated = JobRunning, time.Now()
+	if err := putJob(rec, version); err == ErrConflict {
+		return q.Get(id) // Claimed by someone else
+	} else if err != nil {
+		return rec.Job, err
+	}
+
+	ir := *rec.Report
+	output, renderErr := q.render(ir)
+	output.Warnings = rec.Warnings
+	if renderErr != nil {
+		log.WithError(renderErr).WithField("job", id).Error("render job failed")
+		rec.Status, rec.Error = JobFailed, renderErr.Error()
+	} else {
+		rec.Status, rec.Output = JobSucceeded, &output
+	}
+	// Whoever took over a lost job rendered the same report, so the last write wins
+	rec.Report, rec.Warnings, rec.Updated = nil, nil, time.Now()
+	if err := saveJob(rec); err != nil {
+		return rec.Job, err
+	}
+
+	if q.done != nil {
+		q.done(ir, rec.Job, output, renderErr)
+	}
+	if rec.Draft != "" {
+		recordDraftJob(rec.Draft, rec.DraftQueued, rec.Job)
+	}
+	return rec.Job, nil
+}
+
+// Resume hands the stored jobs which are queued or lost to the workers, as
+// the instance which stored them may have stopped, and deletes the jobs
+// finished more than jobTTL ago
+func (q *jobQueue) Resume() error {
+	keys, err := store.List("jobs/")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, key := range keys {
+		rec, _, err := loadJob(strings.TrimSuffix(strings.TrimPrefix(key, "jobs/"), ".json"))
+		if err != nil {
+			log.WithError(err).Warnf("loading job %s", key)
+			continue
+		}
+		switch {
+		case rec.runnable(now):
+			q.queue <- rec.ID
+		case rec.Status != JobRunning && now.Sub(rec.Updated) > jobTTL:
+			if err := store.Delete(key); err != nil {
+				log.WithError(err).Warnf("deleting job %s", key)
+			}
+		}
+	}
+	return nil
+}
+
+func (q *jobQueue) work() {
+	for id := range q.queue {
+		if _, err := q.Run(id); err != nil {
+			log.WithError(err).WithField("job", id).Error("running job")
+		}
+	}
+}
+
+func handleJobSubmit(w http.ResponseWriter, r *http.Request) {
+	ir, err := decodeReport(r)
+	if err != nil {
+		decodeFailed(w, err)
+		return
+	}
+	if err := resolveUnit(&ir); err != nil {
+		resolveFailed(w, err)
+		return
+	}
+	warnings, err := prepareReport(&ir)
+	if err != nil {
+		validationFailed(w, err)
+		return
+	}
+
+	job, err := jobs.Submit(ir, warnings)
+	if err != nil {
+		log.WithError(err).Error("submitting job")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Infof("Queued %s as job %s", ir.ID, job.ID)
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	response.JSON(w, job, http.StatusAccepted)
+}
+
+// handleJobStatus describes the job {id}, rendering it first when it is still
+// queued or was lost, as the instance which queued it may have been stopped
+func handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	job, err := jobs.Run(mux.Vars(r)["id"])
+	if err == ErrNotFound {
+		response.NotFound(w)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("running job")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response.JSON(w, job)
+}
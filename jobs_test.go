@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestJobs(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+	jobs = newJobQueue(2, 10, func(ir InspectionReport) (responseHTML, error) {
+		if ir.ID == "bad" {
+			return responseHTML{}, errors.New("boom")
+		}
+		return responseHTML{HTML: "https://media.example.com/" + ir.ID + ".html"}, nil
+	})
+	defer func() { jobs = nil }()
+
+	app := mux.NewRouter()
+	app.HandleFunc("/jobs", handleJobSubmit).Methods("POST")
+	app.HandleFunc("/jobs/{id}", handleJobStatus).Methods("GET")
+
+	tests := []struct {
+		id         string
+		wantStatus string
+		wantHTML   string
+		wantErr    string
+	}{
+		{id: "good", wantStatus: JobSucceeded, wantHTML: "https://media.example.com/good.html"},
+		{id: "bad", wantStatus: JobFailed, wantErr: "boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			ir := testReport(t)
+			ir.ID = tt.id
+			body, _ := json.Marshal(ir)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest("POST", "/jobs", bytes.NewReader(body)))
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("POST /jobs = %d %s", w.Code, w.Body)
+			}
+			var job Job
+			json.NewDecoder(w.Body).Decode(&job)
+			if job.Status != JobQueued || job.ReportID != tt.id {
+				t.Errorf("submitted job = %+v", job)
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for job.Status == JobQueued || job.Status == JobRunning {
+				if time.Now().After(deadline) {
+					t.Fatalf("job %s still %s", job.ID, job.Status)
+				}
+				time.Sleep(5 * time.Millisecond)
+				w = httptest.NewRecorder()
+				app.ServeHTTP(w, httptest.NewRequest("GET", "/jobs/"+job.ID, nil))
+				json.NewDecoder(w.Body).Decode(&job)
+			}
+			if job.Status != tt.wantStatus || job.Error != tt.wantErr {
+				t.Errorf("job = %+v", job)
+			}
+			if tt.wantHTML != "" && (job.Output == nil || job.Output.HTML != tt.wantHTML) {
+				t.Errorf("job output = %+v", job.Output)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/jobs/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /jobs/missing = %d", w.Code)
+	}
+}
+
+func TestJobsOtherInstance(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+	render := func(ir InspectionReport) (responseHTML, error) {
+		return responseHTML{HTML: "https://media.example.com/" + ir.ID + ".html"}, nil
+	}
+
+	// Queued by an instance which was stopped before rendering it
+	stopped := newJobQueue(0, 10, render)
+	queued, err := stopped.Submit(testReport(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lost, err := stopped.Submit(testReport(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, version, _ := loadJob(lost.ID)
+	rec.Status, rec.Updated = JobRunning, time.Now().Add(-jobLease-time.Minute)
+	if err := putJob(rec, version); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs = newJobQueue(0, 10, render)
+	defer func() { jobs = nil }()
+	app := mux.NewRouter()
+	app.HandleFunc("/jobs/{id}", handleJobStatus).Methods("GET")
+	for _, id := range []string{queued.ID, lost.ID} {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", "/jobs/"+id, nil))
+		var job Job
+		json.NewDecoder(w.Body).Decode(&job)
+		if w.Code != http.StatusOK || job.Status != JobSucceeded || job.Output == nil {
+			t.Errorf("GET /jobs/%s = %d %+v", id, w.Code, job)
+		}
+	}
+
+	// Running elsewhere
+	running, _ := stopped.Submit(testReport(t), nil)
+	rec, version, _ = loadJob(running.ID)
+	rec.Status = JobRunning
+	putJob(rec, version)
+	if job, err := jobs.Run(running.ID); err != nil || job.Status != JobRunning {
+		t.Errorf("Run() of a running job = %+v, %v", job, err)
+	}
+}
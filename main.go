@@ -5,23 +5,25 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 	neturl "net/url"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"html/template"
 
 	"github.com/apex/log"
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/endpoints"
 	"github.com/aws/aws-sdk-go-v2/aws/external"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
@@ -30,24 +32,88 @@ import (
 )
 
 type responseHTML struct {
-	HTML string
-	JSON string
+	ID       string
+	HTML     string
+	JSON     string
+	PDF      string
+	Summary  *Summary     `json:",omitempty"`
+	Warnings []FieldError `json:",omitempty"` // Problems fixed when the report was received, e.g. of signatures
 }
 
 var e env.Env
 
+// secret reads a secret from the environment, or from SSM once main has set up
+// AWS. Without AWS, e is zero and e.GetSecret would panic.
+var secret = os.Getenv
+
 func main() {
 
-	cfg, err := external.LoadDefaultAWSConfig(external.WithSharedConfigProfile("uneet-dev"))
+	storage := os.Getenv("STORAGE")
+	if storage == "" || storage == "s3" {
+		cfg, err := external.LoadDefaultAWSConfig(external.WithSharedConfigProfile("uneet-dev"))
+		if err != nil {
+			log.WithError(err).Fatal("setting up credentials")
+		}
+		cfg.Region = endpoints.ApSoutheast1RegionID
+		e, err = env.New(cfg)
+		if err != nil {
+			log.WithError(err).Fatal("error getting unee-t env")
+		}
+		secret = e.GetSecret
+	} else {
+		log.Infof("Using %s storage, skipping AWS setup", storage)
+	}
+
+	var err error
+	store, err = newStorage(storage)
 	if err != nil {
-		log.WithError(err).Fatal("setting up credentials")
+		log.WithError(err).Fatal("setting up storage")
 	}
-	cfg.Region = endpoints.ApSoutheast1RegionID
-	e, err = env.New(cfg)
+
+	go func() {
+		if err := indexDumps(); err != nil {
+			log.WithError(err).Error("indexing report dumps")
+		}
+	}()
+
+	units, err = newUnitDirectory(os.Getenv("UNIT_DIRECTORY"))
 	if err != nil {
-		log.WithError(err).Fatal("error getting unee-t env")
+		log.WithError(err).Fatal("setting up unit directory")
 	}
 
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil || workers < 1 {
+		workers = 4
+	}
+	jobs = newJobQueue(workers, 100, genHTML)
+	jobs.done = func(ir InspectionReport, job Job, output responseHTML, err error) {
+		notify(ir, completionEvent(ir, job.ID, output, err))
+	}
+	go func() {
+		if err := resumeDrafts(); err != nil {
+			log.WithError(err).Error("resuming signed drafts")
+		}
+		if err := jobs.Resume(); err != nil {
+			log.WithError(err).Error("resuming render jobs")
+		}
+	}()
+	webhook.Secret = secret("WEBHOOK_SECRET")
+	if webhook.Secret == "" {
+		log.Warn("WEBHOOK_SECRET is not set, no callback is sent")
+	}
+	bugzilla.APIKey = secret("BUGZILLA_API_KEY")
+	thumbnailer.Key = []byte(secret("THUMBNAIL_KEY"))
+	if thumbnailer.URL != "" && len(thumbnailer.Key) == 0 {
+		log.Warn("THUMBNAIL_KEY is not set, images are shown at their full size")
+	}
+
+	sealKey, err = loadSealKey(secret("SEAL_KEY"), storage == "local" || storage == "memory")
+	if err != nil {
+		log.WithError(err).Fatal("loading seal key")
+	}
+
+	apiAccessToken := secret("API_ACCESS_TOKEN")
+
 	addr := ":" + os.Getenv("PORT")
 	app := mux.NewRouter()
 
@@ -56,11 +122,30 @@ func main() {
 		CSRF = csrf.Protect([]byte("32-byte-long-auth-key-yeah"), csrf.Secure(true))
 	}
 
+	app.HandleFunc("/templates/{name:[a-z0-9][a-z0-9_-]*}", env.Towr(env.Protect(http.HandlerFunc(handleTemplateVersions), apiAccessToken))).Methods("GET")
+	app.HandleFunc("/templates/{name}/{version}", env.Towr(env.Protect(http.HandlerFunc(handleTemplateRegister), apiAccessToken))).Methods("PUT")
 	app.PathPrefix("/templates").Handler(http.FileServer(http.Dir(".")))
+	if _, ok := store.(S3Storage); !ok {
+		app.PathPrefix("/media/").HandlerFunc(handleMedia).Methods("GET")
+	}
 	app.HandleFunc("/", env.Towr(CSRF(http.HandlerFunc(handleIndex)))).Methods("GET")
 	app.HandleFunc("/htmlgen", env.Towr(CSRF(http.HandlerFunc(handlePost)))).Methods("POST")
 	app.HandleFunc("/jsonhtmlgen", env.Towr(CSRF(http.HandlerFunc(handleJSON)))).Methods("POST")
-	app.HandleFunc("/", env.Towr(env.Protect(http.HandlerFunc(handleJSON), e.GetSecret("API_ACCESS_TOKEN"))))
+	app.HandleFunc("/", env.Towr(env.Protect(http.HandlerFunc(handleJSON), apiAccessToken)))
+	app.HandleFunc("/reports", env.Towr(env.Protect(http.HandlerFunc(handleReports), apiAccessToken))).Methods("GET")
+	app.HandleFunc("/reports/{id}", env.Towr(env.Protect(http.HandlerFunc(handleReport), apiAccessToken))).Methods("GET")
+	app.HandleFunc("/compare", env.Towr(env.Protect(http.HandlerFunc(handleCompare), apiAccessToken))).Methods("POST")
+	app.HandleFunc("/verify", handleVerify).Methods("POST")
+	app.HandleFunc("/verify/{id}", handleVerifyReport).Methods("GET")
+	app.HandleFunc(schemaPath, handleSchema).Methods("GET")
+	app.HandleFunc("/thumbnail", handleThumbnail).Methods("GET")
+	app.HandleFunc("/jobs", env.Towr(env.Protect(http.HandlerFunc(handleJobSubmit), apiAccessToken))).Methods("POST")
+	app.HandleFunc("/jobs/{id}", env.Towr(env.Protect(http.HandlerFunc(handleJobStatus), apiAccessToken))).Methods("GET")
+	app.HandleFunc("/drafts", env.Towr(env.Protect(http.HandlerFunc(handleDraftCreate), apiAccessToken))).Methods("POST")
+	app.HandleFunc("/drafts/{id}", env.Towr(env.Protect(http.HandlerFunc(handleDraftStatus), apiAccessToken))).Methods("GET")
+	app.HandleFunc("/sign/{draft}/{token}", env.Towr(CSRF(http.HandlerFunc(handleSignPage)))).Methods("GET")
+	app.HandleFunc("/sign/{draft}/{token}", env.Towr(CSRF(http.HandlerFunc(handleSign)))).Methods("POST")
+	app.HandleFunc("/sign/{draft}/{token}/preview", env.Towr(http.HandlerFunc(handleSignPreview))).Methods("GET")
 
 	if err := http.ListenAndServe(addr, app); err != nil {
 		log.WithError(err).Fatal("error listening")
@@ -77,6 +162,7 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 	err := t.ExecuteTemplate(w, "index.html", map[string]interface{}{
 		csrf.TemplateTag: csrf.TemplateField(r),
 		"Stage":          os.Getenv("UP_STAGE"),
+		"Consent":        formConsent(New()),
 	})
 	if err != nil {
 		http.Error(w, err.Error(), 500)
@@ -84,26 +170,60 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleJSON(w http.ResponseWriter, r *http.Request) {
+// errNonConforming is returned by decodeReport for JSON it cannot decode
+var errNonConforming = errors.New("JSON does not conform to the schema at " + schemaPath)
 
-	buf := &bytes.Buffer{}
-	tee := io.TeeReader(r.Body, buf)
+// decodeReport reads a report of any schema_version, see upgradeReport
+func decodeReport(r *http.Request) (ir InspectionReport, err error) {
 	defer r.Body.Close()
-
-	decoder := json.NewDecoder(tee)
-	var ir InspectionReport
-	err := decoder.Decode(&ir)
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
+		return ir, err
+	}
 
+	ir, err = upgradeReport(body)
+	if err != nil {
 		dump, _ := httputil.DumpRequest(r, false)
-		log.WithError(err).Errorf("Dump: %s\nBody: %+v", dump, buf)
-		http.Error(w, "JSON does not conform to https://github.com/unee-t/inspectionreportgenerator/blob/master/structs.go", http.StatusBadRequest)
+		log.WithError(err).Errorf("Dump: %s\nBody: %s", dump, body)
+		return ir, err
+	}
+	dropUnverifiedAudits(&ir)
+	return ir, nil
+}
+
+// decodeFailed replies 400 to JSON that is not a report, 422 to a report that cannot be upgraded
+func decodeFailed(w http.ResponseWriter, err error) {
+	if err == errNonConforming {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	validationFailed(w, err)
+}
+
+func handleJSON(w http.ResponseWriter, r *http.Request) {
+
+	ir, err := decodeReport(r)
+	if err != nil {
+		decodeFailed(w, err)
 		return
 	}
 
 	log.Infof("Generating HTML of %s", ir.ID)
 
+	if err := resolveUnit(&ir); err != nil {
+		resolveFailed(w, err)
+		return
+	}
+
+	warnings, err := prepareReport(&ir)
+	if err != nil {
+		validationFailed(w, err)
+		return
+	}
+
 	output, err := genHTML(ir)
+	output.Warnings = warnings
+	notify(ir, completionEvent(ir, "", output, err))
 	if err != nil {
 		log.WithError(err).Error("genHTML from handleJSON")
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -121,118 +241,7 @@ func handlePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	signoff := InspectionReport{
-		ID:         "12345678",
-		Date:       time.Now(),
-		Signatures: nil,
-		Unit: Unit{
-			Information: Information{
-				Name:        "Unit 01-02",
-				Type:        "Apartment/Flat",
-				Address:     "20 Maple Avenue",
-				Postcode:    "90731",
-				City:        "San Pedro",
-				State:       "California",
-				Country:     "USA",
-				Description: "Blue house with a front porch. Parking is not allowed in the driveway",
-			},
-		},
-		Report: Report{
-			Name: "20 Maple Avenue, Unit 01-02",
-			Images: []string{
-				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/table_succulent.jpg",
-				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_7126.jpg",
-				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/table_succulent.jpg",
-				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_7126.jpg",
-				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/table_succulent.jpg",
-				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_7126.jpg",
-			},
-			Cases: []Case{{
-				Title: "Cracks on Ceiling",
-				Images: []string{
-					"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/inspection_report.jpg",
-				},
-				Category: "Reference",
-				Status:   "Confirmed",
-				Details:  "Worse over time and rain is sometimes seen to be leaking when it rains.",
-			}},
-			Inventory: []Item{{
-				Name:        "Ikea Ivar Shelf",
-				Images:      []string{"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/images.jpg"},
-				Description: "1 in acceptable condition",
-			},
-			},
-			Rooms: []Room{
-				{
-					Name:        "Big Meeting Room",
-					Description: "300 sqft with built-in cabinets, air-con and WiFi",
-					Images:      nil,
-					Cases: []Case{
-						{
-							Title:    "Light is not working",
-							Images:   []string{"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_9411.jpg", "http://res.cloudinary.com/unee-t-staging/image/upload/e_cartoonify/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_9411.jpg"},
-							Category: "Repair",
-							Status:   "Confirmed",
-							Details:  "Lights are unable to turn on after change the light bulb",
-						},
-						{
-							Title:    "Floor stain and the mould seems to smell",
-							Images:   []string{"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/wood_floor_stain.jpg"},
-							Category: "Complex project",
-							Status:   "Reopened",
-							Details:  "Horrible floor statins are appearing due to moisture over time. There is a bad smell.",
-						},
-					},
-					Inventory: nil,
-				},
-				{
-					Name:        "Pantry",
-					Description: "800 sqft, high with built-in cabinets, air-con and WiFi",
-					Images:      []string{"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/pantry.jpg"},
-					Cases:       nil,
-					Inventory: []Item{
-						{
-							Name:        "LG Electronics fridge",
-							Images:      []string{"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/pantry_fridge.jpg"},
-							Description: "1 in acceptable working condition",
-						},
-						{
-							Name:        "Solid Wood long table",
-							Images:      []string{"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/pantry_02.jpg"},
-							Description: "1 in very bad condition. Table is baldy chipped and edges are wearing out.",
-						},
-						{
-							Name:        "Pantry cabinet",
-							Images:      []string{"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/pantry_microwave.jpg"},
-							Description: "1 in good condition. Well maintained.",
-						},
-						{
-							Name:        "Bekant chairs",
-							Images:      []string{"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0522.jpg", "https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0519.jpg"},
-							Description: "12 in mint condition.",
-						},
-						{
-							Name:        "More chairs",
-							Images:      []string{"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0522.jpg", "https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0519.jpg"},
-							Description: "12 in mint condition.",
-						},
-						{
-							Name: "So many more chairs",
-							Images: []string{
-								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0522.jpg",
-								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0519.jpg",
-								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0519.jpg",
-								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0522.jpg",
-								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0522.jpg",
-								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0519.jpg"},
-							Description: "6 in mint condition.",
-						},
-					},
-				},
-			},
-			Comments: "A comment pertaining to the report itself.",
-		},
-	}
+	signoff := New()
 
 	decoder := schema.NewDecoder()
 	decoder.IgnoreUnknownKeys(true)
@@ -244,7 +253,28 @@ func handlePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Signed on the pad just now, by whoever posted the form, whatever it claims
+	now := time.Now()
+	for i := range signoff.Signatures {
+		signoff.Signatures[i].Audit = nil
+		if signoff.Signatures[i].DataURI != "" {
+			signoff.Signatures[i].Audit = auditRequest(r, now, formConsent(signoff))
+		}
+	}
+
+	if err := resolveUnit(&signoff); err != nil {
+		resolveFailed(w, err)
+		return
+	}
+
+	warnings, err := prepareReport(&signoff)
+	if err != nil {
+		validationFailed(w, err)
+		return
+	}
+
 	output, err := genHTML(signoff)
+	output.Warnings = warnings
 	if err != nil {
 		log.WithError(err).Error("failed to decode form")
 		http.Error(w, err.Error(), 500)
@@ -255,26 +285,44 @@ func handlePost(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func dump(svc *s3.S3, filename string, data interface{}) (dumpurl string, err error) {
-	dataJSON, err := json.MarshalIndent(data, "", "    ")
+// dump stores data as JSON under the day, indexed by filename
+func dump(day time.Time, filename string, data interface{}) (dumpurl string, dataJSON []byte, err error) {
+	dataJSON, err = json.MarshalIndent(data, "", "    ")
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	jsonfilename := time.Now().Format("2006-01-02") + "/" + filename + ".json"
-	putparams := &s3.PutObjectInput{
-		Bucket:      aws.String(e.Bucket("media")),
-		Body:        bytes.NewReader(dataJSON),
-		Key:         aws.String(jsonfilename),
-		ACL:         s3.ObjectCannedACLPublicRead,
-		ContentType: aws.String("application/json; charset=UTF-8"),
+	jsonfilename := day.Format("2006-01-02") + "/" + filename + ".json"
+	err = store.Put(jsonfilename, dataJSON, "application/json; charset=UTF-8", Public)
+	if err != nil {
+		return "", nil, err
 	}
+	err = indexDump(filename, jsonfilename, true)
 
-	req := svc.PutObjectRequest(putparams)
-	_, err = req.Send()
+	return store.URL(jsonfilename), dataJSON, err
 
-	return fmt.Sprintf("https://%s/%s", e.Udomain("media"), jsonfilename), err
+}
 
+// handleMedia serves the public artifacts when they are not published to S3
+func handleMedia(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/media/")
+	if s, ok := store.(interface{ Public(key string) bool }); ok && !s.Public(key) {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := store.Get(key)
+	if err == ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ct := mime.TypeByExtension(path.Ext(key)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Write(body)
 }
 
 // CloudinaryTransform takes a Cloudinary URL and outputs the transformations we want to see
@@ -289,13 +337,42 @@ func CloudinaryTransform(url string, transforms string) (transformedURL string)
 	if uParsed.Host != "res.cloudinary.com" {
 		return ""
 	}
-	uParsed.Scheme = "https"
-	s := strings.Split(uParsed.Path, "/")
+	return cloudinaryURL(uParsed, transforms)
+}
+
+// cloudinaryURL is u, on res.cloudinary.com or a host mapped to it, with transforms
+func cloudinaryURL(u *neturl.URL, transforms string) string {
+	v := *u
+	v.Scheme = "https"
+	s := strings.Split(v.Path, "/")
+	if len(s) < 3 {
+		return ""
+	}
 	s = append(s[:2], append([]string{transforms}, s[2:]...)...)
 	// log.Infof("%+v", s)
-	uParsed.Path = strings.Join(append(s[0:3], s[len(s)-2:]...), "/")
-	// log.Infof("Right? %+v", uParsed.Path)
-	return uParsed.String()
+	v.Path = strings.Join(append(s[0:3], s[len(s)-2:]...), "/")
+	// log.Infof("Right? %+v", v.Path)
+	return v.String()
+}
+
+// templateFuncs are available to signoff.html and any custom template
+var templateFuncs = template.FuncMap{
+	"prettyDate": func(d time.Time) string { return d.Format("2 Jan 2006") },
+	"ymdDate":    func(d time.Time) string { return d.Format("2006-01-02") },
+	"increment":  func(i int) int { return i + 1 },
+	"domain":     func(s string) string { return e.Udomain(s) },
+	"pdfURL":     func(ir InspectionReport) string { return store.URL(pdfKey(ir)) },
+	"transform":  transformImage,
+	"conditions": conditionSummary,
+}
+
+// absoluteURL is path on the host r was sent to
+func absoluteURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
 }
 
 func randomHex(n int) (string, error) {
@@ -314,84 +391,86 @@ func genHTML(ir InspectionReport) (output responseHTML, err error) {
 		log.Infof("Empty logo, set to: %s", ir.Logo)
 	}
 
+	if err := bugzilla.importCases(&ir); err != nil {
+		return output, fmt.Errorf("importing cases from Bugzilla: %v", err)
+	}
+
+	ir.SchemaVersion = reportVersion
+	structureInventory(&ir)
+	ir.Summary = summarise(ir.Report)
+
 	randomString, err := randomHex(4)
 
 	if !ir.Force {
 		ir.ID = fmt.Sprintf("%s-%s", ir.ID, randomString)
 	}
 
-	var t *template.Template
-	var b bytes.Buffer
-
-	templateFuncs := template.FuncMap{
-		"prettyDate": func(d time.Time) string { return d.Format("2 Jan 2006") },
-		"ymdDate":    func(d time.Time) string { return d.Format("2006-01-02") },
-		"increment":  func(i int) int { return i + 1 },
-		"domain":     func(s string) string { return e.Udomain(s) },
-		"transform":  CloudinaryTransform,
-	}
-
-	if ir.Template == "" {
-		// Use default template
-		t, err = template.New("").Funcs(templateFuncs).ParseFiles("templates/signoff.html")
-
-		if err != nil {
-			return output, err
-		}
-		err = t.ExecuteTemplate(io.Writer(&b), "signoff.html", ir)
-	} else {
-		resp, err := http.Get(ir.Template)
-		if err != nil {
-			return output, err
-		}
-		defer resp.Body.Close()
-		contents, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return output, err
-		}
-		tmpl, err := template.New("").Funcs(templateFuncs).Parse(string(contents))
-		err = tmpl.Execute(io.Writer(&b), ir)
+	t, resolved, err := loadTemplate(ir.Template)
+	if err != nil {
+		return output, err
 	}
+	// Record which version was used, so regenerating gives the same report
+	ir.Template = resolved
 
+	seal, err := sealReport(ir)
 	if err != nil {
 		return output, err
 	}
+	ir.Seal = &seal
 
-	cfg, err := external.LoadDefaultAWSConfig(external.WithSharedConfigProfile("uneet-dev"))
+	var b bytes.Buffer
+	err = t.Execute(io.Writer(&b), ir)
 	if err != nil {
 		return output, err
 	}
-	svc := s3.New(cfg)
 
-	dumpurl, err := dump(svc, ir.ID, ir)
+	// The artifacts share the day of the inspection, see pdfKey
+	day := ir.Date.Format("2006-01-02")
+	dumpurl, dumpJSON, err := dump(ir.Date, ir.ID, ir)
 	if err != nil {
 		return output, err
 	}
 	log.Infof("dumpurl %s", dumpurl)
-
-	htmlfilename := time.Now().Format("2006-01-02") + "/" + ir.ID + ".html"
-	if ir.Force {
-		htmlfilename = ir.Date.Format("2006-01-02") + "/" + ir.ID + ".html"
+	err = recordSeal(ir, "json", dumpurl, dumpJSON)
+	if err != nil {
+		return output, err
 	}
 
-	putparams := &s3.PutObjectInput{
-		Bucket:      aws.String(e.Bucket("media")),
-		Body:        bytes.NewReader(b.Bytes()),
-		Key:         aws.String(htmlfilename),
-		ACL:         s3.ObjectCannedACLPublicRead,
-		ContentType: aws.String("text/html; charset=UTF-8"),
-	}
+	htmlfilename := day + "/" + ir.ID + ".html"
 
-	req := svc.PutObjectRequest(putparams)
-	_, err = req.Send()
+	page, err := imageEmbedder.Embed(b.Bytes(), ir.Render, strings.TrimSuffix(htmlfilename, ".html")+"/")
+	if err != nil {
+		return output, err
+	}
+	err = store.Put(htmlfilename, page, "text/html; charset=UTF-8", Public)
+	if err != nil {
+		return output, err
+	}
+	err = recordSeal(ir, "html", store.URL(htmlfilename), page)
+	if err != nil {
+		return output, err
+	}
 
+	pdffilename := pdfKey(ir)
+	pdf, err := genPDF(ir, store.URL(pdffilename))
+	if err != nil {
+		return output, err
+	}
+	err = store.Put(pdffilename, pdf, "application/pdf", Public)
+	if err != nil {
+		return output, err
+	}
+	err = recordSeal(ir, "pdf", store.URL(pdffilename), pdf)
 	if err != nil {
 		return output, err
 	}
 
 	return responseHTML{
-		HTML: fmt.Sprintf("https://%s/%s", e.Udomain("media"), htmlfilename),
-		JSON: dumpurl,
+		ID:      ir.ID,
+		HTML:    store.URL(htmlfilename),
+		JSON:    dumpurl,
+		PDF:     store.URL(pdffilename),
+		Summary: ir.Summary,
 	}, err
 
 }
@@ -92,7 +92,9 @@ func TestSignoffIsValid(t *testing.T) {
 		"ymdDate":    func(d time.Time) string { return d.Format("2006-01-02") },
 		"increment":  func(i int) int { return i + 1 },
 		"domain":     func(s string) string { return fmt.Sprintf("%s.example.com", s) },
+		"pdfURL":     func(ir InspectionReport) string { return "https://media.example.com/" + pdfKey(ir) },
 		"transform":  func(a, b string) string { return "foobar" },
+		"conditions": conditionSummary,
 	}).ParseFiles("templates/signoff.html")
 	if err != nil {
 		t.Errorf("signoff.html failed to parse, error = %v", err)
@@ -158,3 +160,31 @@ func TestSignoffIsValid(t *testing.T) {
 	}
 
 }
+
+// renderSignoff is ir rendered with signoff.html and the functions of the service
+func renderSignoff(t *testing.T, ir InspectionReport) string {
+	if store == nil {
+		store = NewMemoryStorage("http://localhost/media")
+		defer func() { store = nil }()
+	}
+	tmpl, err := template.New("").Funcs(templateFuncs).ParseFiles("templates/signoff.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := tmpl.ExecuteTemplate(&b, "signoff.html", ir); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestSignoffLinksPDF(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+
+	ir := New()
+	want := `<a href="http://localhost/media/` + ir.Date.Format("2006-01-02") + "/" + ir.ID + `.pdf">`
+	if html := renderSignoff(t, ir); strings.Count(html, want) != 2 {
+		t.Errorf("signoff.html does not link to the stored PDF %s", want)
+	}
+}
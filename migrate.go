@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportVersion is the SchemaVersion of the reports this service writes.
+// Bump it and append to migrations when the format of structs.go changes.
+const reportVersion = 1
+
+// migration upgrades a decoded dump in place by one version
+type migration func(doc map[string]interface{}) error
+
+// migrations[n] upgrades a dump of version n to version n+1
+var migrations = []migration{
+	upgradeGoFieldNames, // 0: dumps written before structs.go had json tags
+}
+
+// dumpVersion tells the version of a dump, which did not always record it
+func dumpVersion(doc map[string]interface{}) (int, error) {
+	if v, ok := doc["schema_version"]; ok {
+		n, ok := v.(float64)
+		if !ok || n < 0 || n != float64(int(n)) {
+			return 0, fmt.Errorf("schema_version must be a positive integer")
+		}
+		if int(n) > reportVersion {
+			return 0, fmt.Errorf("schema_version %d is newer than %d, the latest this service knows", int(n), reportVersion)
+		}
+		return int(n), nil
+	}
+	if _, ok := doc["ID"]; ok {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// upgradeReport decodes a dump of any version into the current structure.
+// Fields of a versioned or upgraded dump that have no place in it are reported
+// rather than dropped. Other reports, as integrators send them, may carry
+// fields of their own, which are ignored.
+func upgradeReport(body []byte) (ir InspectionReport, err error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return ir, errNonConforming
+	}
+	version, err := dumpVersion(doc)
+	if err != nil {
+		return ir, err
+	}
+	_, versioned := doc["schema_version"]
+	strict := versioned || version < reportVersion
+	for ; version < reportVersion; version++ {
+		if err := migrations[version](doc); err != nil {
+			return ir, fmt.Errorf("upgrading from schema_version %d: %v", version, err)
+		}
+	}
+	doc["schema_version"] = float64(reportVersion)
+
+	if strict {
+		var unknown ValidationErrors
+		reportSchema.unknownFields(reportSchema, doc, "", &unknown)
+		if len(unknown) > 0 {
+			return ir, unknown
+		}
+	}
+
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return ir, err
+	}
+	if err := json.Unmarshal(upgraded, &ir); err != nil {
+		return ir, decodeError(doc, err)
+	}
+	ir.doc = doc
+	return ir, nil
+}
+
+// decodeError is the field at fault when doc could not be decoded into a
+// report, else errNonConforming
+func decodeError(doc map[string]interface{}, err error) error {
+	switch err := err.(type) {
+	case *json.UnmarshalTypeError:
+		if err.Field == "" {
+			break
+		}
+		var typ interface{} = "object"
+		if s := typeSchema(err.Type, make(map[string]*JSONSchema)); s.Type != nil {
+			typ = s.Type
+		}
+		return ValidationErrors{{Field: fieldPath(err.Field), Message: fmt.Sprintf("must be %v, not %s", typ, err.Value)}}
+	case *time.ParseError:
+		// Which time it was is not told, the schema finds it
+		var errs, times ValidationErrors
+		reportSchema.validate(reportSchema, doc, "", &errs)
+		for _, e := range errs {
+			if e.Message == "must be a valid date-time" {
+				times = append(times, e)
+			}
+		}
+		if len(times) > 0 {
+			return times
+		}
+	}
+	return errNonConforming
+}
+
+// fieldPath writes a path of encoding/json, e.g. report.rooms.2.name, as
+// FieldError does, e.g. report.rooms[2].name
+func fieldPath(jsonPath string) string {
+	var b strings.Builder
+	for i, key := range strings.Split(jsonPath, ".") {
+		if _, err := strconv.Atoi(key); err == nil {
+			b.WriteString("[" + key + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(key)
+	}
+	return b.String()
+}
+
+// upgradeGoFieldNames renames the keys encoding/json used by default, e.g.
+// "ID" to "id". Report.Creator becomes report.creator, see creator.go, unless
+// empty as it was in most dumps.
+func upgradeGoFieldNames(doc map[string]interface{}) error {
+	if report, ok := doc["Report"].(map[string]interface{}); ok {
+		if creator, ok := report["Creator"]; ok && creator == "" {
+			delete(report, "Creator")
+		}
+	}
+	renameFields(doc, reflect.TypeOf(InspectionReport{}))
+	return nil
+}
+
+// renameFields renames the Go field names of t found in v to their JSON names.
+// When both are present, for example after editing a dump with jq, the JSON name wins.
+func renameFields(v interface{}, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return
+		}
+		for _, item := range v {
+			renameFields(item, t.Elem())
+		}
+	case map[string]interface{}:
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := jsonName(f)
+			if name == "-" || f.PkgPath != "" {
+				continue
+			}
+			if old, ok := v[f.Name]; ok && f.Name != name {
+				if _, ok := v[name]; !ok {
+					v[name] = old
+				}
+				delete(v, f.Name)
+			}
+			if child, ok := v[name]; ok {
+				renameFields(child, f.Type)
+			}
+		}
+	}
+}
+
+// unknownFields reports the keys of v that s has no property for
+func (s *JSONSchema) unknownFields(root *JSONSchema, v interface{}, path string, errs *ValidationErrors) {
+	if s.Ref != "" {
+		if def, ok := root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]; ok {
+			def.unknownFields(root, v, path, errs)
+		}
+		return
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.unknownFields(root, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case map[string]interface{}:
+		if s.Properties == nil {
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			prop, ok := s.Properties[key]
+			if s.ignored[key] {
+				continue
+			}
+			if !ok {
+				errs.add(joinPath(path, key), "unknown field")
+				continue
+			}
+			prop.unknownFields(root, v[key], joinPath(path, key), errs)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMigrationsCoverEveryVersion(t *testing.T) {
+	if len(migrations) != reportVersion {
+		t.Errorf("%d migrations for reportVersion %d", len(migrations), reportVersion)
+	}
+}
+
+func TestUpgradeLegacyDump(t *testing.T) {
+	body, err := ioutil.ReadFile("tests/test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ir, err := upgradeReport(body)
+	if err != nil {
+		t.Fatalf("upgradeReport() error = %v", err)
+	}
+	if ir.SchemaVersion != reportVersion || ir.ID != "12345678" || ir.Date.IsZero() {
+		t.Errorf("upgraded = %d %q %v", ir.SchemaVersion, ir.ID, ir.Date)
+	}
+	if len(ir.Signatures) != 1 || ir.Signatures[0].Name != "Test" || ir.Signatures[0].DataURI == "" {
+		t.Errorf("signatures = %+v", ir.Signatures)
+	}
+	if ir.Unit.Information.Name != "Unit 01-02" || ir.Report.Comments == "" || ir.Report.Cases[0].Title != "Cracks on Ceiling" {
+		t.Errorf("report = %+v", ir.Report)
+	}
+	if len(ir.Report.Rooms) == 0 || ir.Report.Rooms[0].Cases[0].Details == "" {
+		t.Errorf("rooms = %+v", ir.Report.Rooms)
+	}
+	if err := validateReport(ir); err != nil {
+		t.Errorf("upgraded dump does not validate: %v", err)
+	}
+
+	// What the legacy dump had is all still there once upgraded
+	var before map[string]interface{}
+	json.Unmarshal(body, &before)
+	after, _ := json.Marshal(ir)
+	for _, value := range []string{"Ikea Ivar Shelf", "1 in acceptable condition", "Light is not working", "Blue house with a front porch"} {
+		if !bytes.Contains(after, []byte(value)) {
+			t.Errorf("%q lost in the upgrade", value)
+		}
+	}
+}
+
+func TestUpgradeReportErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"not JSON", `{`, errNonConforming.Error()},
+		{"newer version", `{"schema_version": 99, "id": "a"}`, "schema_version 99 is newer than 1, the latest this service knows"},
+		{"unknown field", `{"schema_version": 1, "id": "a", "report": {"rooms": [{"name": "Pantry", "colour": "blue"}]}}`, "report.rooms[0].colour: unknown field"},
+		{"wrong type", `{"id": "a", "signatures": "x"}`, "signatures: must be [array null], not string"},
+		{"wrong type in a list", `{"id": "a", "report": {"rooms": [{"name": 1}]}}`, "report.rooms[0].name: must be string, not number"},
+		{"not a time", `{"id": "a", "date": "tomorrow"}`, "date: must be a valid date-time"},
+		{"unknown field of a legacy dump", `{"ID": "a", "Report": {"Rooms": [{"Name": "Pantry", "Colour": "blue"}]}}`, "report.rooms[0].Colour: unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := upgradeReport([]byte(tt.body))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("upgradeReport() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpgradeReportIgnoresUnknownFields(t *testing.T) {
+	ir, err := upgradeReport([]byte(`{"id": "a", "tenant_ref": "T-1", "report": {"rooms": [{"name": "Pantry", "colour": "blue"}]}}`))
+	if err != nil || ir.ID != "a" || ir.Report.Rooms[0].Name != "Pantry" {
+		t.Errorf("upgradeReport() = %+v, %v, want fields of the integrator ignored", ir, err)
+	}
+}
+
+func TestUpgradeLegacyCreator(t *testing.T) {
+	ir, err := upgradeReport([]byte(`{"ID": "a", "Signatures": [{"Name": "Kai", "Email": "kai@example.com"}], "Report": {"Name": "r", "Creator": "kai@example.com"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ir.Report.Creator != "kai@example.com" || len(ir.CreatedBy()) != 1 {
+		t.Errorf("creator = %q, created by %+v", ir.Report.Creator, ir.CreatedBy())
+	}
+}
+
+func TestHandleJSONLegacyDump(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+
+	body, err := ioutil.ReadFile("tests/test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handleJSON(w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST / = %d %s", w.Code, w.Body)
+	}
+	var output responseHTML
+	json.NewDecoder(w.Body).Decode(&output)
+
+	_, dumped, err := findDump("", output.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(dumped), `"schema_version": 1`) {
+		t.Errorf("regenerated dump has no schema_version: %s", dumped[:200])
+	}
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/apex/log"
+
+	// Signatures from the signature pad are PNG, but allow JPEG too
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// A4 in points, margins loosely follow the @page rule in signoff.html
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 50
+	pdfTop        = 110 // room for the header
+	pdfBottom     = 90  // room for the footer
+	pdfWidth      = pdfPageWidth - 2*pdfMargin
+)
+
+// Colours from signoff.html
+var (
+	pdfBlue    = [3]float64{0, 0.6, 0.737}       // #0099BC
+	pdfGrey    = [3]float64{0.302, 0.404, 0.431} // #4D676E
+	pdfHeading = [3]float64{0.88, 0.952, 0.968}  // rgba(0, 153, 188, 0.12)
+	pdfRule    = [3]float64{0.8, 0.8, 0.8}       // #ccc
+	pdfMissing = [3]float64{1, 0.753, 0.796}     // pink
+	pdfBlack   = [3]float64{0, 0, 0}
+	pdfWhite   = [3]float64{1, 1, 1}
+)
+
+// genPDF renders ir following the layout of signoff.html. Photos are left to
+// the HTML version, only the signatures are embedded.
+func genPDF(ir InspectionReport, pdfurl string) ([]byte, error) {
+	d := &pdfDoc{title: "Unit Inspection Report - " + ir.Report.Name}
+
+	d.header = func(w *bytes.Buffer, page, pages int) {
+		top := pdfPageHeight - 50
+		pdfText(w, pdfMargin, top, 16, true, pdfBlack, "Unit Inspection Report")
+		pdfText(w, pdfMargin, top-18, 12, true, pdfBlack, ir.Report.Name)
+		for i, s := range []string{"Reference: " + ir.ID, "Created on: " + ir.Date.Format("2 Jan 2006")} {
+			pdfText(w, pdfPageWidth-pdfMargin-pdfStringWidth(s, 8, false), top+12-float64(i)*10, 8, false, pdfBlack, s)
+		}
+	}
+	d.footer = func(w *bytes.Buffer, page, pages int) {
+		pdfRect(w, pdfMargin, 50, pdfWidth, 22, pdfBlue)
+		pdfText(w, pdfMargin+8, 58, 8, false, pdfWhite, "Generated by Unee-T.com | Smarter Unit Management")
+		pdfText(w, pdfPageWidth-pdfMargin-8-pdfStringWidth(pdfurl, 8, false), 58, 8, false, pdfWhite, pdfurl)
+		pager := fmt.Sprintf("Page %d of %d", page, pages)
+		pdfText(w, (pdfPageWidth-pdfStringWidth(pager, 8, false))/2, 35, 8, false, pdfBlack, pager)
+		if ir.Seal != nil {
+			fingerprint := "Fingerprint: " + ir.Seal.Hash
+			pdfText(w, (pdfPageWidth-pdfStringWidth(fingerprint, 6, false))/2, 25, 6, false, pdfGrey, fingerprint)
+		}
+	}
+
+	if sum := ir.Summary; sum != nil {
+		d.heading("Summary")
+		cases := fmt.Sprint(sum.Cases)
+		if sum.Cases > 0 {
+			cases += fmt.Sprintf(" (%d open)", sum.OpenCases)
+		}
+		d.row("Cases", cases)
+		if sum.Cases > 0 {
+			d.row("By category", sum.ByCategory())
+			d.row("By status", sum.ByStatus())
+		}
+		d.row("Items (total quantity)", fmt.Sprint(sum.InventoryItems))
+		if len(sum.Conditions) > 0 {
+			d.row("Condition", sum.Condition())
+		}
+		d.row("In poor condition", fmt.Sprint(sum.ItemsInPoorCondition))
+		rooms := strings.Join(sum.RoomsWithOpenIssues, ", ")
+		if rooms == "" {
+			rooms = "None"
+		}
+		d.row("Rooms with open issues", rooms)
+	}
+
+	d.heading("Unit Information")
+	info := ir.Unit.Information
+	d.row("Unit Name", info.Name)
+	d.row("Unit Type", info.Type)
+	d.gap(6)
+	d.row("Address", info.Address)
+	d.row("City", info.City)
+	d.row("Zip/Postal code", info.Postcode)
+	d.row("State", info.State)
+	d.row("Country", info.Country)
+	d.gap(6)
+	d.row("Unit description", info.Description)
+	d.row("Additional comments", ir.Report.Comments)
+
+	d.subheading("Reported issues with the unit")
+	d.cases(ir.Report.Cases)
+	d.subheading("Inventory for unit")
+	if len(ir.Report.Inventory) > 0 {
+		d.row("Condition", conditionSummary(ir.Report.Inventory))
+		d.gap(6)
+	}
+	d.inventory(ir.Report.Inventory)
+
+	for i, room := range ir.Report.Rooms {
+		d.heading(fmt.Sprintf("Room %d - %s", i+1, room.Name))
+		d.row("Cases", fmt.Sprint(room.CaseCount()))
+		d.row("Inventory items", fmt.Sprint(len(room.Inventory)))
+		if len(room.Inventory) > 0 {
+			d.row("Condition", conditionSummary(room.Inventory))
+		}
+		d.row("Description", room.Description)
+		if len(room.Cases) > 0 {
+			d.subheading("Reported issues with the " + room.Name)
+			d.cases(room.Cases)
+		}
+		if len(room.Inventory) > 0 {
+			d.subheading("Inventory for " + room.Name)
+			d.inventory(room.Inventory)
+		}
+	}
+
+	if items := ir.ActionItems(); len(items) > 0 {
+		d.heading("Action items")
+		for _, item := range items {
+			d.title4(item.Title)
+			d.row("Where", item.Where)
+			responsible := item.Responsible
+			if item.Assignee != "" {
+				responsible = strings.TrimSpace(responsible + " (" + item.Assignee + ")")
+			}
+			d.followUp(Case{Priority: item.Priority, Assignee: responsible, Due: item.Due, EstimatedCost: item.EstimatedCost})
+			d.separator()
+		}
+	}
+
+	if sigs := ir.CreatedBy(); len(sigs) > 0 {
+		d.signatures("Report created by", sigs)
+	}
+	if sigs := ir.Involved(); len(sigs) > 0 {
+		d.signatures("People involved", sigs)
+	}
+
+	if sigs := ir.AuditedSignatures(); len(sigs) > 0 {
+		d.heading("Appendix: signature audit")
+		for _, s := range sigs {
+			who := s.Name
+			if s.Role != "" {
+				who += " (" + s.Role + ")"
+			}
+			d.title4(who)
+			d.row("Signed at", s.Audit.When())
+			d.row("IP address", s.Audit.IP)
+			d.row("Browser", s.Audit.UserAgent)
+			location := "Not shared"
+			if s.Audit.Location != nil {
+				location = s.Audit.Location.String()
+			}
+			d.row("Location", location)
+			if s.Audit.Consent != "" {
+				d.row("Agreed to", s.Audit.Consent)
+			}
+			d.separator()
+		}
+	}
+
+	return d.bytes()
+}
+
+func (d *pdfDoc) cases(cases []Case) {
+	for _, c := range cases {
+		d.title4(c.Title)
+		d.row("Category", c.Category)
+		d.row("Status", c.Status)
+		d.row("Details", c.Details)
+		d.followUp(c)
+		d.separator()
+	}
+}
+
+// followUp are the rows of the follow-up fields that are set
+func (d *pdfDoc) followUp(c Case) {
+	for _, r := range [][2]string{{"Priority", c.Priority}, {"Responsible", c.Assignee}, {"Due", c.Due}, {"Estimated cost", c.Cost()}} {
+		if r[1] != "" {
+			d.row(r[0], r[1])
+		}
+	}
+}
+
+func (d *pdfDoc) inventory(items []Item) {
+	for _, item := range items {
+		d.title4(item.Name)
+		if item.Quantity > 0 {
+			d.row("Quantity", fmt.Sprint(item.Quantity))
+		}
+		for _, r := range [][2]string{{"Condition", item.Condition}, {"Brand", item.Brand}, {"Serial", item.Serial}} {
+			if r[1] != "" {
+				d.row(r[0], r[1])
+			}
+		}
+		d.para(item.Description)
+		for _, c := range item.Cases {
+			d.row("Issue", c.Title)
+			d.row("Category", c.Category)
+			d.row("Status", c.Status)
+			d.row("Details", c.Details)
+			d.followUp(c)
+			d.gap(4)
+		}
+		d.separator()
+	}
+}
+
+// signatures is a bordered box of up to four signatures per row, like #allsignatures
+func (d *pdfDoc) signatures(label string, sigs []Signature) {
+	const (
+		pad     = 10
+		perRow  = 4
+		sigH    = 50
+		blockH  = 12 + 12 + sigH + 8
+		labelH  = 18
+		columnW = (pdfWidth - 2*pad) / perRow
+	)
+	rows := (len(sigs) + perRow - 1) / perRow
+	height := float64(pad + labelH + rows*blockH + pad)
+	d.space(height + 10)
+	d.gap(10)
+	w := d.out()
+	top := d.y
+	pdfBorder(w, pdfMargin, top-height, pdfWidth, height, pdfBlue)
+	pdfText(w, pdfMargin+pad, top-pad-9, 9, true, pdfGrey, label)
+
+	for i, s := range sigs {
+		x := pdfMargin + pad + float64(i%perRow)*columnW
+		y := top - pad - labelH - float64(i/perRow*blockH)
+		pdfText(w, x, y-10, 9, true, pdfBlack, s.Name)
+		pdfText(w, x, y-22, 8, false, pdfBlack, s.Role)
+		name, width, err := d.signatureImage(s)
+		if err != nil {
+			log.WithError(err).Warnf("signature of %s", s.Name)
+			pdfRect(w, x, y-40, pdfStringWidth("MISSING SIGNATURE", 8, true)+4, 12, pdfMissing)
+			pdfText(w, x+2, y-37, 8, true, pdfBlack, "MISSING SIGNATURE")
+			continue
+		}
+		if width > columnW-pad {
+			width = columnW - pad
+		}
+		pdfDrawImage(w, name, x, y-26-sigH, width, sigH)
+	}
+	d.gap(height)
+}
+
+// signatureImage embeds the DataURI, returning its width when drawn 50pt high
+func (d *pdfDoc) signatureImage(s Signature) (name string, width float64, err error) {
+	if s.DataURI == "" {
+		return "", 0, fmt.Errorf("no data URI")
+	}
+	_, data, err := decodeDataURI(string(s.DataURI))
+	if err != nil {
+		return "", 0, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", 0, err
+	}
+	name, err = d.addImage(img)
+	b := img.Bounds()
+	return name, 50 * float64(b.Dx()) / float64(b.Dy()), err
+}
+
+type pdfImage struct {
+	width, height int
+	data          []byte // zlib compressed RGB
+}
+
+// pdfDoc lays out text top to bottom on A4 pages with the standard Helvetica fonts
+type pdfDoc struct {
+	pages  []*bytes.Buffer
+	y      float64 // from the bottom of the page, as PDF does
+	images []pdfImage
+	title  string
+
+	// header and footer are drawn on every page once the page count is known
+	header func(w *bytes.Buffer, page, pages int)
+	footer func(w *bytes.Buffer, page, pages int)
+}
+
+func (d *pdfDoc) addPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfTop
+}
+
+// space breaks the page unless h points are left
+func (d *pdfDoc) space(h float64) {
+	if len(d.pages) == 0 || d.y-h < pdfBottom {
+		d.addPage()
+	}
+}
+
+func (d *pdfDoc) out() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.addPage()
+	}
+	return d.pages[len(d.pages)-1]
+}
+
+func (d *pdfDoc) gap(h float64) {
+	d.y -= h
+}
+
+// heading is a h2, a tinted band across the page
+func (d *pdfDoc) heading(s string) {
+	d.space(50)
+	d.gap(10)
+	pdfRect(d.out(), pdfMargin, d.y-20, pdfWidth, 20, pdfHeading)
+	pdfText(d.out(), pdfMargin+6, d.y-14, 11, true, pdfBlack, s)
+	d.gap(30)
+}
+
+// subheading is a h3, underlined
+func (d *pdfDoc) subheading(s string) {
+	d.space(40)
+	d.gap(10)
+	pdfText(d.out(), pdfMargin, d.y-11, 11, true, pdfGrey, s)
+	pdfRect(d.out(), pdfMargin, d.y-16, pdfWidth, 0.5, pdfBlue)
+	d.gap(26)
+}
+
+// title4 is a h4
+func (d *pdfDoc) title4(s string) {
+	d.lines(pdfMargin, pdfWidth, 11, true, pdfBlack, s)
+	d.gap(2)
+}
+
+// row is a label and value table row
+func (d *pdfDoc) row(label, value string) {
+	const labelWidth = 110
+	lines := pdfWrap(value, 9, false, pdfWidth-labelWidth)
+	d.space(12)
+	pdfText(d.out(), pdfMargin, d.y-9, 9, true, pdfGrey, label)
+	for i, l := range lines {
+		if i > 0 {
+			d.space(12)
+		}
+		pdfText(d.out(), pdfMargin+labelWidth, d.y-9, 9, false, pdfBlack, l)
+		d.gap(12)
+	}
+	if len(lines) == 0 {
+		d.gap(12)
+	}
+}
+
+// para is wrapped body text
+func (d *pdfDoc) para(s string) {
+	d.lines(pdfMargin, pdfWidth, 9, false, pdfBlack, s)
+	d.gap(4)
+}
+
+func (d *pdfDoc) lines(x, width, size float64, bold bool, c [3]float64, s string) {
+	for _, l := range pdfWrap(s, size, bold, width) {
+		d.space(size + 4)
+		pdfText(d.out(), x, d.y-size, size, bold, c, l)
+		d.gap(size + 4)
+	}
+}
+
+// separator is the rule between .item blocks
+func (d *pdfDoc) separator() {
+	d.gap(6)
+	pdfRect(d.out(), pdfMargin, d.y, pdfWidth, 0.5, pdfRule)
+	d.gap(10)
+}
+
+// addImage registers img and returns its resource name
+func (d *pdfDoc) addImage(img image.Image) (name string, err error) {
+	b := img.Bounds()
+	var raw bytes.Buffer
+	zw := zlib.NewWriter(&raw)
+	row := make([]byte, 0, b.Dx()*3)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row = row[:0]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			// Flatten transparency onto white, the signature pad background
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			a := uint32(c.A)
+			row = append(row,
+				byte((uint32(c.R)*a+255*(255-a))/255),
+				byte((uint32(c.G)*a+255*(255-a))/255),
+				byte((uint32(c.B)*a+255*(255-a))/255))
+		}
+		if _, err := zw.Write(row); err != nil {
+			return "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	d.images = append(d.images, pdfImage{width: b.Dx(), height: b.Dy(), data: raw.Bytes()})
+	return fmt.Sprintf("Im%d", len(d.images)-1), nil
+}
+
+// bytes assembles the document
+func (d *pdfDoc) bytes() ([]byte, error) {
+	if len(d.pages) == 0 {
+		d.addPage()
+	}
+
+	var objs [][]byte
+	add := func(format string, a ...interface{}) int {
+		objs = append(objs, []byte(fmt.Sprintf(format, a...)))
+		return len(objs)
+	}
+	stream := func(dict string, data []byte) int {
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "<< %s /Length %d >>\nstream\n", dict, len(data))
+		b.Write(data)
+		b.WriteString("\nendstream")
+		objs = append(objs, b.Bytes())
+		return len(objs)
+	}
+
+	// Object numbers are fixed for the catalog, pages and fonts
+	add("<< /Type /Catalog /Pages 2 0 R >>")
+	pagesObj := add("") // filled in once the kids are known
+	add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	info := add("<< /Title (%s) /Producer (Unee-T inspectionreportgenerator) >>", pdfEscape(d.title))
+
+	var xobjects strings.Builder
+	for i, img := range d.images {
+		n := stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode",
+			img.width, img.height), img.data)
+		fmt.Fprintf(&xobjects, "/Im%d %d 0 R ", i, n)
+	}
+
+	var kids []string
+	for i, body := range d.pages {
+		var content bytes.Buffer
+		if d.header != nil {
+			d.header(&content, i+1, len(d.pages))
+		}
+		content.Write(body.Bytes())
+		if d.footer != nil {
+			d.footer(&content, i+1, len(d.pages))
+		}
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		if _, err := zw.Write(content.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		c := stream("/Filter /FlateDecode", z.Bytes())
+		p := add("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> /XObject << %s>> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, xobjects.String(), c)
+		kids = append(kids, fmt.Sprintf("%d 0 R", p))
+	}
+	objs[pagesObj-1] = []byte(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objs))
+	for i, o := range objs {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n", i+1)
+		b.Write(o)
+		b.WriteString("\nendobj\n")
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, info, xref)
+	return b.Bytes(), nil
+}
+
+func pdfText(w *bytes.Buffer, x, y, size float64, bold bool, c [3]float64, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(w, "%.3f %.3f %.3f rg BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", c[0], c[1], c[2], font, size, x, y, pdfEscape(s))
+}
+
+func pdfRect(w *bytes.Buffer, x, y, width, height float64, c [3]float64) {
+	fmt.Fprintf(w, "%.3f %.3f %.3f rg %.2f %.2f %.2f %.2f re f\n", c[0], c[1], c[2], x, y, width, height)
+}
+
+func pdfBorder(w *bytes.Buffer, x, y, width, height float64, c [3]float64) {
+	fmt.Fprintf(w, "%.3f %.3f %.3f RG 0.5 w %.2f %.2f %.2f %.2f re S\n", c[0], c[1], c[2], x, y, width, height)
+}
+
+func pdfDrawImage(w *bytes.Buffer, name string, x, y, width, height float64) {
+	fmt.Fprintf(w, "q %.2f 0 0 %.2f %.2f %.2f cm /%s Do Q\n", width, height, x, y, name)
+}
+
+// winAnsi maps the few non Latin-1 runes people type into reports
+var winAnsi = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94,
+	'•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// pdfEncode converts s to WinAnsiEncoding, replacing what cannot be shown
+func pdfEncode(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			b = append(b, ' ')
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			b = append(b, byte(r))
+		case winAnsi[r] != 0:
+			b = append(b, winAnsi[r])
+		default:
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, c := range pdfEncode(s) {
+		switch c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			if c >= 0x80 {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// Glyph widths of the printable ASCII range, from the Adobe font metrics
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// pdfStringWidth is the width of s in points
+func pdfStringWidth(s string, size float64, bold bool) float64 {
+	widths := &helveticaWidths
+	if bold {
+		widths = &helveticaBoldWidths
+	}
+	total := 0
+	for _, c := range pdfEncode(s) {
+		if c >= 0x20 && c < 0x7f {
+			total += widths[c-0x20]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// pdfWrap breaks s into lines no wider than width, keeping explicit line breaks
+func pdfWrap(s string, size float64, bold bool, width float64) (lines []string) {
+	for _, para := range strings.Split(s, "\n") {
+		words := strings.Fields(para)
+		if len(words) == 0 {
+			continue
+		}
+		line := words[0]
+		for _, w := range words[1:] {
+			if pdfStringWidth(line+" "+w, size, bold) > width {
+				lines = append(lines, line)
+				line = w
+				continue
+			}
+			line += " " + w
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// decodeDataURI returns the MIME type and payload of a data: URI
+func decodeDataURI(uri string) (mimeType string, data []byte, err error) {
+	if !strings.HasPrefix(uri, "data:") {
+		return "", nil, fmt.Errorf("not a data URI")
+	}
+	comma := strings.IndexByte(uri, ',')
+	if comma < 0 {
+		return "", nil, fmt.Errorf("data URI has no payload")
+	}
+	meta, payload := uri[len("data:"):comma], uri[comma+1:]
+	params := strings.Split(meta, ";")
+	mimeType = params[0]
+	if params[len(params)-1] != "base64" {
+		return "", nil, fmt.Errorf("data URI is not base64 encoded")
+	}
+	data, err = base64.StdEncoding.DecodeString(payload)
+	return mimeType, data, err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestGenPDF(t *testing.T) {
+	byteValue, err := ioutil.ReadFile("templates/dump.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ir InspectionReport
+	if err := json.Unmarshal(byteValue, &ir); err != nil {
+		t.Fatal(err)
+	}
+
+	pdf, err := genPDF(ir, "https://media.example.com/2018-08-20/12345678.pdf")
+	if err != nil {
+		t.Fatalf("genPDF() error = %v", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("not a PDF")
+	}
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	offset, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(pdf[offset:], []byte("xref\n")) {
+		t.Errorf("startxref %d does not point at the xref table", offset)
+	}
+
+	if n := bytes.Count(pdf, []byte("/Subtype /Image")); n != len(ir.Signatures) {
+		t.Errorf("embedded %d signature images, want %d", n, len(ir.Signatures))
+	}
+	if !regexp.MustCompile(`/Count [2-9]`).Match(pdf) {
+		t.Error("expected the rooms to run over several pages")
+	}
+}
+
+func TestPDFWrap(t *testing.T) {
+	lines := pdfWrap("Horrible floor statins are appearing due to moisture over time. There is a bad smell.", 9, false, 150)
+	if len(lines) < 2 {
+		t.Fatalf("pdfWrap() = %q, expected several lines", lines)
+	}
+	for _, l := range lines {
+		if w := pdfStringWidth(l, 9, false); w > 150 {
+			t.Errorf("line %q is %.1f wide", l, w)
+		}
+	}
+}
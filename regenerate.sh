@@ -48,27 +48,17 @@ echo Working on $fn
 
 cat <<< "$(jq ".force += true " < $fn)" > $fn
 
-HTML=$(curl -X POST \
+OUTPUT=$(curl -X POST \
 	https://$(udomain $STAGE pdfgen) \
 	-H "Authorization: Bearer $(aws --profile $AWS_PROFILE ssm get-parameters --names API_ACCESS_TOKEN --with-decryption --query Parameters[0].Value --output text)" \
 	-H 'Content-Type: application/json' \
 	-H 'cache-control: no-cache' \
-	--data @$fn |
-	jq -r .HTML)
+	--data @$fn)
 
-echo New output: $HTML
+# step 3, the PDF is rendered alongside the HTML
 
-# step 3, regenerate PDF
-
-date=$(jq -r .date < $fn)
-echo Setting date: $date
-
-# document_url must be from the host unee-t.com btw
-curl -X POST \
-	https://$(udomain $STAGE prince) \
-	-H 'Content-Type: application/json' \
-	-H 'cache-control: no-cache' \
-	-d "{ \"document_url\": \"$HTML\", \"date\": \"$date\"}"
+echo New output: $(echo $OUTPUT | jq -r .HTML)
+echo New PDF: $(echo $OUTPUT | jq -r .PDF)
 
 #aws --profile uneet-dev cloudfront create-invalidation --distribution-id E2L4KVYCVKXLA1 --invalidation-batch "{ \"Paths\": { \"Quantity\": 1, \"Items\": [ \"/*\" ] }, \"CallerReference\": \"$(shell date +%s)\" }"
 #aws --profile uneet-prod cloudfront create-invalidation --distribution-id E3NBG008M01XS8 --invalidation-batch "{ \"Paths\": { \"Quantity\": 1, \"Items\": [ \"/*\" ] }, \"CallerReference\": \"$(shell date +%s)\" }"
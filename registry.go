@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"github.com/tj/go/http/response"
+)
+
+// builtinTemplate is templates/signoff.html, the default when a report has no Template
+const (
+	builtinTemplate        = "signoff"
+	builtinTemplateVersion = "v1"
+)
+
+var (
+	templateNameRe    = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	templateVersionRe = regexp.MustCompile(`^v([0-9]{1,6})$`)
+)
+
+// templateRegistry holds named and versioned templates in the store under
+// templates/<name>/<version>.html. Versions are immutable, so parsed
+// templates are cached for the life of the process.
+type templateRegistry struct {
+	mu     sync.Mutex
+	parsed map[string]*template.Template
+}
+
+var registry = &templateRegistry{parsed: make(map[string]*template.Template)}
+
+func templateKey(name, version string) string {
+	return "templates/" + name + "/" + version + ".html"
+}
+
+// parseTemplateRef splits "handover@v3", version is empty for "handover".
+// Versions are normalised, "handover@v03" is "handover@v3".
+func parseTemplateRef(ref string) (name, version string, err error) {
+	name = ref
+	if i := strings.LastIndexByte(ref, '@'); i >= 0 {
+		name, version = ref[:i], ref[i+1:]
+		if !templateVersionRe.MatchString(version) {
+			return "", "", fmt.Errorf("template %q: version must look like v3", ref)
+		}
+		version = "v" + strconv.Itoa(versionNumber(version))
+	}
+	if !templateNameRe.MatchString(name) {
+		return "", "", fmt.Errorf("template %q: name must be lowercase letters, digits, - or _", ref)
+	}
+	return name, version, nil
+}
+
+func versionNumber(version string) int {
+	m := templateVersionRe.FindStringSubmatch(version)
+	if m == nil {
+		return -1
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// parseTemplate checks src with templateFuncs and renders the example report,
+// so a template referring to missing fields is rejected up front
+func parseTemplate(name string, src []byte) (*template.Template, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(ioutil.Discard, New()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Versions lists the versions of name, oldest first
+func (r *templateRegistry) Versions(name string) ([]string, error) {
+	if name == builtinTemplate {
+		return []string{builtinTemplateVersion}, nil
+	}
+	keys, err := store.List("templates/" + name + "/")
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, k := range keys {
+		v := strings.TrimSuffix(path.Base(k), ".html")
+		if templateVersionRe.MatchString(v) {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versionNumber(versions[i]) < versionNumber(versions[j]) })
+	return versions, nil
+}
+
+// Lookup resolves a reference such as "handover@v3", or "handover" for the
+// latest version, returning the template and the fully qualified reference
+func (r *templateRegistry) Lookup(ref string) (t *template.Template, resolved string, err error) {
+	name, version, err := parseTemplateRef(ref)
+	if err != nil {
+		return nil, "", err
+	}
+	if version == "" {
+		versions, err := r.Versions(name)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(versions) == 0 {
+			return nil, "", fmt.Errorf("template %q is not registered", name)
+		}
+		version = versions[len(versions)-1]
+	}
+	resolved = name + "@" + version
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.parsed[resolved]; ok {
+		return t, resolved, nil
+	}
+
+	var src []byte
+	if name == builtinTemplate {
+		if version != builtinTemplateVersion {
+			return nil, "", fmt.Errorf("template %q is not registered", resolved)
+		}
+		src, err = ioutil.ReadFile("templates/signoff.html")
+	} else {
+		src, err = store.Get(templateKey(name, version))
+		if err == ErrNotFound {
+			return nil, "", fmt.Errorf("template %q is not registered", resolved)
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	t, err = template.New(resolved).Funcs(templateFuncs).Parse(string(src))
+	if err != nil {
+		return nil, "", err
+	}
+	r.parsed[resolved] = t
+	return t, resolved, nil
+}
+
+// Register validates and stores a new version of a template, returning its
+// normalised reference. Versions are written once, concurrent registrations
+// of the same version get errTemplateExists.
+func (r *templateRegistry) Register(name, version string, src []byte) (string, error) {
+	if name == builtinTemplate {
+		return "", fmt.Errorf("template %q is built in", name)
+	}
+	name, version, err := parseTemplateRef(name + "@" + version)
+	if err != nil {
+		return "", err
+	}
+	ref := name + "@" + version
+	if _, err := parseTemplate(ref, src); err != nil {
+		return "", err
+	}
+	err = store.PutIf(templateKey(name, version), src, "text/html; charset=UTF-8", Private, "")
+	if err == ErrConflict {
+		return "", errTemplateExists
+	}
+	return ref, err
+}
+
+var errTemplateExists = fmt.Errorf("template version already exists, register a new version instead")
+
+// loadTemplate resolves the Template of a report: empty for the built-in
+// signoff.html, a legacy http(s) URL, or a registry reference
+func loadTemplate(ref string) (t *template.Template, resolved string, err error) {
+	switch {
+	case ref == "":
+		t, _, err = registry.Lookup(builtinTemplate + "@" + builtinTemplateVersion)
+		return t, "", err
+	case strings.HasPrefix(ref, "http://"), strings.HasPrefix(ref, "https://"):
+		t, err = fetchTemplate(ref)
+		return t, ref, err
+	default:
+		return registry.Lookup(ref)
+	}
+}
+
+// fetchTemplate parses the template at url, see Fetcher
+func fetchTemplate(url string) (*template.Template, error) {
+	contents, err := templateFetcher.Fetch(url)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(url).Funcs(templateFuncs).Parse(string(contents))
+}
+
+func handleTemplateRegister(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	src, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	ref, err := registry.Register(vars["name"], vars["version"], src)
+	if err == errTemplateExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Warnf("rejected template %s@%s", vars["name"], vars["version"])
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	response.JSON(w, map[string]string{"template": ref}, http.StatusCreated)
+}
+
+func handleTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !templateNameRe.MatchString(name) {
+		response.NotFound(w)
+		return
+	}
+	versions, err := registry.Versions(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		response.NotFound(w)
+		return
+	}
+	response.JSON(w, map[string]interface{}{"name": name, "versions": versions})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+)
+
+func TestTemplateRegistry(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	registry = &templateRegistry{parsed: make(map[string]*template.Template)}
+	defer func() { store = nil }()
+
+	tests := []struct {
+		name, version, src string
+		wantErr            bool
+	}{
+		{"handover", "v1", `<p>v1 {{ .Report.Name }}</p>`, false},
+		{"handover", "v2", `<p>v2 {{ prettyDate .Date }}</p>`, false},
+		{"handover", "v2", `<p>again</p>`, true},
+		{"handover", "v02", `<p>again</p>`, true},
+		{"broken", "v1", `<p>{{ .Report.Name </p>`, true},
+		{"unknown-field", "v1", `<p>{{ .Report.Nope }}</p>`, true},
+		{"unknown-func", "v1", `<p>{{ shout .ID }}</p>`, true},
+		{"signoff", "v2", `<p>mine</p>`, true},
+		{"Bad Name", "v1", `<p></p>`, true},
+		{"handover", "3", `<p></p>`, true},
+	}
+	for _, tt := range tests {
+		_, err := registry.Register(tt.name, tt.version, []byte(tt.src))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Register(%s, %s) error = %v, wantErr %v", tt.name, tt.version, err, tt.wantErr)
+		}
+	}
+
+	if _, resolved, err := registry.Lookup("handover"); err != nil || resolved != "handover@v2" {
+		t.Errorf("Lookup(handover) = %s, %v", resolved, err)
+	}
+	if _, resolved, err := registry.Lookup("handover@v01"); err != nil || resolved != "handover@v1" {
+		t.Errorf("Lookup(handover@v01) = %s, %v", resolved, err)
+	}
+	if ref, err := registry.Register("handover", "v003", []byte(`<p>v3</p>`)); err != nil || ref != "handover@v3" {
+		t.Errorf("Register(handover, v003) = %s, %v", ref, err)
+	}
+	if _, _, err := registry.Lookup("handover@v9"); err == nil {
+		t.Error("Lookup(handover@v9) should fail")
+	}
+	if _, resolved, err := registry.Lookup("signoff"); err != nil || resolved != "signoff@v1" {
+		t.Errorf("Lookup(signoff) = %s, %v", resolved, err)
+	}
+
+	ir := New()
+	ir.Template = "handover@v1"
+	tmpl, _, _ := loadTemplate(ir.Template)
+	var b strings.Builder
+	if err := tmpl.Execute(&b, ir); err != nil || b.String() != "<p>v1 20 Maple Avenue, Unit 01-02</p>" {
+		t.Errorf("handover@v1 rendered %q, %v", b.String(), err)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"github.com/tj/go/http/response"
+)
+
+// ReportEntry is what the service knows of a report it generated
+type ReportEntry struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Date     time.Time `json:"date"` // Of the inspection
+	Day      string    `json:"day"`  // Of the dated key, YYYY-MM-DD, see artifactURLs
+	UnitID   string    `json:"unit_id,omitempty"`
+	Unit     string    `json:"unit"` // Name of the unit
+	SignedBy []string  `json:"signed_by"`
+	Sealed   bool      `json:"sealed"`
+	Summary  *Summary  `json:"summary,omitempty"`
+	JSON     string    `json:"json"`
+	HTML     string    `json:"html"`
+	PDF      string    `json:"pdf"`
+
+	key string // Of the JSON dump, the cursor of listReports
+}
+
+// artifactURLs are where genHTML put the JSON dump at key and its HTML and PDF.
+// They share the day of the inspection, but reports generated before they
+// did have their JSON and HTML under the day they were generated.
+func artifactURLs(key string, ir InspectionReport) (json, html, pdf string) {
+	return store.URL(key),
+		store.URL(path.Dir(key) + "/" + ir.ID + ".html"),
+		store.URL(pdfKey(ir))
+}
+
+// pdfKey is where genHTML puts the PDF of ir, which signoff.html links to
+func pdfKey(ir InspectionReport) string {
+	return ir.Date.Format("2006-01-02") + "/" + ir.ID + ".pdf"
+}
+
+// reportEntry describes ir, stored at key
+func reportEntry(key string, ir InspectionReport) ReportEntry {
+	entry := ReportEntry{
+		ID:       ir.ID,
+		Name:     ir.Report.Name,
+		Date:     ir.Date,
+		Day:      path.Dir(key),
+		UnitID:   ir.Unit.ID,
+		Unit:     ir.Unit.Information.Name,
+		SignedBy: []string{},
+		Sealed:   ir.Seal != nil,
+		Summary:  ir.Summary,
+		key:      key,
+	}
+	for _, s := range ir.Signatures {
+		entry.SignedBy = append(entry.SignedBy, s.Name)
+	}
+	entry.JSON, entry.HTML, entry.PDF = artifactURLs(key, ir)
+	return entry
+}
+
+// comparisonKeyRe matches the keys of the JSON dumps of genComparison, whose
+// IDs are those of both reports and a random suffix
+var comparisonKeyRe = regexp.MustCompile(`-vs-.+-[0-9a-f]{8}\.json$`)
+
+// Bounds of the days listReports goes through
+const (
+	reportsWindow  = 90  // Days listed before To when there is no From
+	maxReportsDays = 366 // Days one query may span
+)
+
+// Number of reports listReports returns at once
+const (
+	defaultReportsLimit = 50
+	maxReportsLimit     = 200
+)
+
+// ReportQuery narrows listReports. From and To are inclusive days of the dated
+// keys of the reports, as YYYY-MM-DD, see artifactURLs, and Unit is the ID or
+// name of their unit.
+// Cursor is the key of the last report of the previous page.
+type ReportQuery struct {
+	Unit     string
+	From, To string
+	Limit    int
+	Cursor   string
+}
+
+func (q ReportQuery) matches(ir InspectionReport) bool {
+	return q.Unit == "" || ir.Unit.ID == q.Unit || sameName(ir.Unit.Information.Name, q.Unit)
+}
+
+// listReports are up to q.Limit stored reports matching q, by day of their
+// dated key from To back to From, the latest key of a day first. A regenerated
+// report is listed once, at its latest key. next is the Cursor of the following page,
+// empty when there is none.
+func listReports(q ReportQuery) (entries []ReportEntry, next string, err error) {
+	to, err := time.Parse("2006-01-02", q.To)
+	if err != nil {
+		return nil, "", err
+	}
+	from, err := time.Parse("2006-01-02", q.From)
+	if err != nil {
+		return nil, "", err
+	}
+	if m := dumpKeyRe.FindStringSubmatch(q.Cursor); m != nil && m[1] < q.To {
+		to, _ = time.Parse("2006-01-02", m[1])
+	}
+
+	entries = []ReportEntry{}
+	for day := to; !day.Before(from); day = day.AddDate(0, 0, -1) {
+		keys, err := store.List(day.Format("2006-01-02") + "/")
+		if err != nil {
+			return nil, "", err
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+		for _, key := range keys {
+			m := dumpKeyRe.FindStringSubmatch(key)
+			if m == nil || comparisonKeyRe.MatchString(key) || (q.Cursor != "" && key >= q.Cursor) {
+				continue
+			}
+			if latest, err := indexedDump(m[2]); err == nil && latest != key {
+				continue // Regenerated since
+			}
+			ir, ok, err := readReport(key)
+			if err != nil {
+				return nil, "", err
+			}
+			if !ok || !q.matches(ir) {
+				continue
+			}
+			if len(entries) == q.Limit {
+				return entries, entries[len(entries)-1].key, nil
+			}
+			entries = append(entries, reportEntry(key, ir))
+		}
+	}
+	return entries, "", nil
+}
+
+// readReport reads the dump at key, false when it is not a readable report
+func readReport(key string) (ir InspectionReport, ok bool, err error) {
+	body, err := store.Get(key)
+	if err == ErrNotFound {
+		return ir, false, nil
+	}
+	if err != nil {
+		return ir, false, err
+	}
+	ir, err = upgradeReport(body)
+	if err != nil {
+		log.WithError(err).Warnf("skipping unreadable report %s", key)
+		return ir, false, nil
+	}
+	return ir, true, nil
+}
+
+// handleReport describes the report {id}
+func handleReport(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	ir, key, err := findReport("", id)
+	if err == ErrNotFound {
+		response.NotFound(w)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Errorf("finding report %s", id)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response.JSON(w, reportEntry(key, ir))
+}
+
+// handleReports lists the reports matching ?unit=, ?from= and ?to=, by pages
+// of ?limit= reports. The next page is at ?cursor= with the next of the answer.
+func handleReports(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := ReportQuery{
+		Unit:   strings.TrimSpace(query.Get("unit")),
+		From:   query.Get("from"),
+		To:     query.Get("to"),
+		Limit:  defaultReportsLimit,
+		Cursor: query.Get("cursor"),
+	}
+	for _, day := range []string{q.From, q.To} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			http.Error(w, "from and to must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if q.To == "" {
+		q.To = time.Now().Format("2006-01-02")
+	}
+	to, _ := time.Parse("2006-01-02", q.To)
+	if q.From == "" {
+		q.From = to.AddDate(0, 0, -reportsWindow).Format("2006-01-02")
+	}
+	from, _ := time.Parse("2006-01-02", q.From)
+	if from.After(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+	if to.Sub(from) > maxReportsDays*24*time.Hour {
+		http.Error(w, fmt.Sprintf("from and to must be at most %d days apart", maxReportsDays), http.StatusBadRequest)
+		return
+	}
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxReportsLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxReportsLimit), http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
+	if q.Cursor != "" && !dumpKeyRe.MatchString(q.Cursor) {
+		http.Error(w, "cursor must be the next of a previous page", http.StatusBadRequest)
+		return
+	}
+
+	entries, next, err := listReports(q)
+	if err != nil {
+		log.WithError(err).Error("listing reports")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page := map[string]interface{}{"reports": entries}
+	if next != "" {
+		page["next"] = next
+	}
+	response.JSON(w, page)
+}
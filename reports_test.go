@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestReportsAPI(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+
+	put := func(key string, v interface{}) {
+		body, _ := json.Marshal(v)
+		if err := store.Put(key, body, "application/json", Public); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ir := testReport(t)
+	ir.ID, ir.Unit.ID = "movein-1", "unit-0102"
+	put("2018-08-01/movein-1.json", ir)
+	store.Put("2018-08-01/movein-1.html", []byte("<html>"), "text/html", Public)
+	ir.Report.Name = "Regenerated"
+	put("2018-08-03/movein-1.json", ir)
+	other := testReport(t)
+	other.ID, other.Unit.Information.Name = "moveout-2", "Unit 07-01"
+	put("2018-08-02/moveout-2.json", other)
+	put("2018-08-02/movein-1-vs-moveout-2-0a1b2c3d.json", Comparison{ID: "movein-1-vs-moveout-2-0a1b2c3d", Before: ir, After: other})
+	store.Put("2018-08-02/broken.json", []byte("{"), "application/json", Public)
+	put("drafts/0123.json", ir)
+	if err := indexDumps(); err != nil {
+		t.Fatal(err)
+	}
+
+	app := mux.NewRouter()
+	app.HandleFunc("/reports", handleReports).Methods("GET")
+	app.HandleFunc("/reports/{id}", handleReport).Methods("GET")
+	var next string
+	list := func(query string) (ids []string) {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", "/reports"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /reports%s = %d %s", query, w.Code, w.Body)
+		}
+		var got struct {
+			Reports []ReportEntry
+			Next    string
+		}
+		json.NewDecoder(w.Body).Decode(&got)
+		next = got.Next
+		ids = []string{}
+		for _, e := range got.Reports {
+			ids = append(ids, e.ID+"@"+e.Day)
+		}
+		return ids
+	}
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{}},
+		{"?to=2018-08-31", []string{"movein-1@2018-08-03", "moveout-2@2018-08-02"}},
+		{"?to=2018-08-31&unit=unit-0102", []string{"movein-1@2018-08-03"}},
+		{"?to=2018-08-31&unit=unit%2007-01", []string{"moveout-2@2018-08-02"}},
+		{"?from=2018-08-02&to=2018-08-02", []string{"moveout-2@2018-08-02"}},
+		{"?to=2018-08-01", []string{}}, // Listed where it was regenerated
+		{"?from=2018-09-01&to=2018-09-30", []string{}},
+	} {
+		if got := list(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GET /reports%s = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+	if got := list("?to=2018-08-31&limit=1"); !reflect.DeepEqual(got, []string{"movein-1@2018-08-03"}) || next != "2018-08-03/movein-1.json" {
+		t.Errorf("first page = %q, next %q", got, next)
+	}
+	if got := list("?to=2018-08-31&limit=1&cursor=" + next); !reflect.DeepEqual(got, []string{"moveout-2@2018-08-02"}) || next != "" {
+		t.Errorf("second page = %q, next %q", got, next)
+	}
+
+	for _, query := range []string{"?from=yesterday", "?from=2018-08-03&to=2018-08-01", "?from=2017-01-01&to=2018-08-01",
+		"?limit=0", "?limit=1000", "?cursor=drafts/0123.json"} {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", "/reports"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GET /reports%s = %d", query, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/reports/movein-1", nil))
+	var entry ReportEntry
+	json.NewDecoder(w.Body).Decode(&entry)
+	want := ReportEntry{
+		ID: "movein-1", Name: "Regenerated", Date: ir.Date, Day: "2018-08-03",
+		UnitID: "unit-0102", Unit: ir.Unit.Information.Name, SignedBy: []string{"Test", "Ng"},
+		JSON: "http://localhost/media/2018-08-03/movein-1.json",
+		HTML: "http://localhost/media/2018-08-03/movein-1.html",
+		PDF:  "http://localhost/media/" + ir.Date.Format("2006-01-02") + "/movein-1.pdf",
+	}
+	if !reflect.DeepEqual(entry, want) {
+		t.Errorf("GET /reports/movein-1 = %+v, want %+v", entry, want)
+	}
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/reports/nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /reports/nope = %d", w.Code)
+	}
+}
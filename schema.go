@@ -0,0 +1,464 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	neturl "net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schemaVersion changes with incompatible changes to the report format, along with the API
+const schemaVersion = "v1"
+
+// schemaPath is the stable URL of the JSON Schema of InspectionReport
+const schemaPath = "/schema/" + schemaVersion + "/inspectionreport.json"
+
+// JSONSchema is the subset of JSON Schema 2020-12 needed to describe structs.go
+type JSONSchema struct {
+	Schema       string                 `json:"$schema,omitempty"`
+	ID           string                 `json:"$id,omitempty"`
+	Ref          string                 `json:"$ref,omitempty"`
+	Title        string                 `json:"title,omitempty"`
+	Type         interface{}            `json:"type,omitempty"` // A type or a list of types
+	Format       string                 `json:"format,omitempty"`
+	Pattern      string                 `json:"pattern,omitempty"`
+	MinLength    *int                   `json:"minLength,omitempty"`
+	MaxLength    *int                   `json:"maxLength,omitempty"`
+	Enum         []string               `json:"enum,omitempty"`
+	Minimum      *float64               `json:"minimum,omitempty"`
+	Maximum      *float64               `json:"maximum,omitempty"`
+	MinItems     *int                   `json:"minItems,omitempty"`
+	MaxItems     *int                   `json:"maxItems,omitempty"`
+	Items        *JSONSchema            `json:"items,omitempty"`
+	Properties   map[string]*JSONSchema `json:"properties,omitempty"`
+	Required     []string               `json:"required,omitempty"`
+	Defs         map[string]*JSONSchema `json:"$defs,omitempty"`
+	Additional   *bool                  `json:"additionalProperties,omitempty"`
+	ErrorMessage string                 `json:"errorMessage,omitempty"` // As in ajv-errors, replaces the pattern and format failure messages
+
+	order   []string        // Properties in struct order, so errors follow the document
+	pattern *regexp.Regexp  // Pattern, compiled
+	ignored map[string]bool // Properties set by the service, accepted but not published
+}
+
+// reportSchema is generated from structs.go and the jsonschema tags of its fields
+var reportSchema = generateSchema(reflect.TypeOf(InspectionReport{}))
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func intPtr(i int) *int { return &i }
+
+func generateSchema(t reflect.Type) *JSONSchema {
+	defs := make(map[string]*JSONSchema)
+	root := schemaOf(t, defs)
+	root.Schema = "https://json-schema.org/draft/2020-12/schema"
+	root.Title = t.Name()
+	root.Defs = defs
+	return root
+}
+
+// schemaOf describes the fields of struct t, recording nested structs in defs
+func schemaOf(t reflect.Type, defs map[string]*JSONSchema) *JSONSchema {
+	s := &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema), Additional: new(bool), ignored: make(map[string]bool)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := jsonName(f)
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if f.Tag.Get("jsonschema") == "-" {
+			s.ignored[name] = true
+			continue
+		}
+		prop := typeSchema(f.Type, defs)
+		if applyTags(prop, f.Type, f.Tag.Get("jsonschema")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+		s.order = append(s.order, name)
+	}
+	return s
+}
+
+// jsonName is the key of f in JSON, "-" when it is left out
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func typeSchema(t reflect.Type, defs map[string]*JSONSchema) *JSONSchema {
+	if t == timeType {
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), defs)
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		// encoding/json writes nil slices as null
+		return &JSONSchema{Type: []string{"array", "null"}, Items: typeSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil // Breaks cycles
+			defs[t.Name()] = schemaOf(t, defs)
+		}
+		return &JSONSchema{Ref: "#/$defs/" + t.Name()}
+	}
+	return &JSONSchema{}
+}
+
+// applyTags handles `jsonschema:"required,url,minItems=1"`, returning whether the field is required.
+// Keywords about strings apply to the items of a list of strings.
+func applyTags(s *JSONSchema, t reflect.Type, tag string) (required bool) {
+	str := s
+	if s.Items != nil {
+		str = s.Items
+	}
+	for _, kw := range strings.Split(tag, ",") {
+		key, value := kw, ""
+		if i := strings.IndexByte(kw, '='); i >= 0 {
+			key, value = kw[:i], kw[i+1:]
+		}
+		switch key {
+		case "":
+		case "required":
+			required = true
+			if t.Kind() == reflect.String {
+				// Blank is the same as missing, unless a later keyword sets a pattern
+				s.MinLength, s.Pattern, s.ErrorMessage = intPtr(1), `\S`, "required"
+			}
+		case "id":
+			str.Pattern, str.MaxLength = `^[A-Za-z0-9][A-Za-z0-9._-]*$`, intPtr(128)
+			str.ErrorMessage = "must be letters, digits, ., - or _"
+		case "url":
+			str.Format, str.Pattern = "uri", `^https?://[^/?#\s]+`
+			str.ErrorMessage = "must be an absolute http or https URL"
+			if str != s {
+				str.MinLength = intPtr(1) // An empty item is not unset
+			}
+		case "email":
+			str.Format = "email"
+		case "date":
+			str.Format = "date"
+		case "enum":
+			str.Enum = strings.Split(value, "|")
+		case "minimum", "maximum":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				panic(fmt.Sprintf("jsonschema tag %q: %v", tag, err))
+			}
+			if key == "minimum" {
+				s.Minimum = &n
+			} else {
+				s.Maximum = &n
+			}
+		case "minItems", "maxItems":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				panic(fmt.Sprintf("jsonschema tag %q: %v", tag, err))
+			}
+			if key == "minItems" {
+				s.MinItems = &n
+			} else {
+				s.MaxItems = &n
+			}
+		default:
+			panic(fmt.Sprintf("jsonschema tag %q: unknown keyword %q", tag, key))
+		}
+	}
+	for _, s := range []*JSONSchema{s, str} {
+		if s.Pattern != "" {
+			s.pattern = regexp.MustCompile(s.Pattern)
+		}
+	}
+	return required
+}
+
+// jsonType is the JSON Schema type of a value decoded by encoding/json
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func (s *JSONSchema) allows(typ string) bool {
+	switch t := s.Type.(type) {
+	case nil:
+		return true
+	case string:
+		return t == typ || (t == "number" && typ == "integer")
+	case []string:
+		for _, t := range t {
+			if t == typ || (t == "number" && typ == "integer") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// validate appends to errs how v, decoded by encoding/json, breaks s
+func (s *JSONSchema) validate(root *JSONSchema, v interface{}, path string, errs *ValidationErrors) {
+	if s.Ref != "" {
+		def, ok := root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+		if !ok {
+			errs.add(path, "unknown schema %s", s.Ref)
+			return
+		}
+		def.validate(root, v, path, errs)
+		return
+	}
+	typ := jsonType(v)
+	if !s.allows(typ) {
+		errs.add(path, "must be %v, not %s", s.Type, typ)
+		return
+	}
+
+	switch v := v.(type) {
+	case string:
+		s.validateString(v, path, errs)
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			errs.add(path, "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			errs.add(path, "must be at most %v", *s.Maximum)
+		}
+	case []interface{}:
+		switch {
+		case s.MinItems != nil && len(v) < *s.MinItems:
+			errs.add(path, "at least %d required", *s.MinItems)
+		case s.MaxItems != nil && len(v) > *s.MaxItems:
+			errs.add(path, "at most %d allowed", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(root, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case map[string]interface{}:
+		order := s.order
+		if order == nil {
+			for key := range s.Properties {
+				order = append(order, key)
+			}
+			sort.Strings(order)
+		}
+		for _, key := range order {
+			value, ok := v[key]
+			if value == nil && contains(s.Required, key) {
+				errs.add(joinPath(path, key), "required")
+				continue
+			}
+			if ok {
+				s.Properties[key].validate(root, value, joinPath(path, key), errs)
+			}
+		}
+	case nil:
+		// Arrays may be null, as encoding/json writes nil slices
+		if s.MinItems != nil && *s.MinItems > 0 {
+			errs.add(path, "at least %d required", *s.MinItems)
+		}
+	}
+}
+
+func (s *JSONSchema) validateString(v, path string, errs *ValidationErrors) {
+	message := func(format string, args ...interface{}) {
+		if s.ErrorMessage != "" {
+			errs.add(path, s.ErrorMessage)
+			return
+		}
+		errs.add(path, format, args...)
+	}
+	switch {
+	case v == "" && s.MinLength == nil:
+		// Empty is unset, encoding/json writes it for every string
+	case s.MinLength != nil && *s.MinLength == 1 && v == "":
+		errs.add(path, "required")
+	case s.MinLength != nil && len(v) < *s.MinLength:
+		message("at least %d characters", *s.MinLength)
+	case s.MaxLength != nil && len(v) > *s.MaxLength:
+		message("at most %d characters", *s.MaxLength)
+	case s.pattern != nil && !s.pattern.MatchString(v):
+		message("must match %s", s.Pattern)
+	case s.Format != "" && !validFormat(s.Format, v):
+		message("must be a valid %s", s.Format)
+	case s.Enum != nil && !contains(s.Enum, v):
+		errs.add(path, "must be one of %s", strings.Join(s.Enum, ", "))
+	}
+}
+
+func validFormat(format, v string) bool {
+	var err error
+	switch format {
+	case "date-time":
+		_, err = time.Parse(time.RFC3339Nano, v)
+	case "date":
+		_, err = time.Parse("2006-01-02", v)
+	case "email":
+		_, err = mail.ParseAddress(v)
+	case "uri":
+		_, err = neturl.ParseRequestURI(v)
+	}
+	return err == nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSchema checks ir against reportSchema as it was sent, with the
+// values the service changed since, e.g. those filled from the unit
+// directory. Keys the request left out stay out, so that missing required
+// ones are reported.
+func validateSchema(ir InspectionReport) (errs ValidationErrors, err error) {
+	doc, err := toDocument(ir)
+	if err != nil {
+		return nil, err
+	}
+	if ir.doc != nil {
+		var sent InspectionReport
+		b, err := json.Marshal(ir.doc)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &sent); err != nil {
+			return nil, err
+		}
+		before, err := toDocument(sent)
+		if err != nil {
+			return nil, err
+		}
+		doc = mergeDocument(ir.doc, before, doc)
+	}
+	reportSchema.validate(reportSchema, doc, "", &errs)
+	return errs, nil
+}
+
+// toDocument is ir as encoding/json writes it, without the zero times it
+// writes for unset ones
+func toDocument(ir InspectionReport) (interface{}, error) {
+	b, err := json.Marshal(ir)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	dropZeroTimes(doc)
+	return doc, nil
+}
+
+var zeroTime, _ = time.Time{}.MarshalText()
+
+func dropZeroTimes(v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			dropZeroTimes(item)
+		}
+	case map[string]interface{}:
+		for key, value := range v {
+			if value == string(zeroTime) {
+				delete(v, key)
+				continue
+			}
+			dropZeroTimes(value)
+		}
+	}
+}
+
+// mergeDocument is now, the document of a report, without the keys missing
+// from sent, the document it was decoded from, unless they changed since
+// before, the document of sent as decoded
+func mergeDocument(sent, before, now interface{}) interface{} {
+	switch n := now.(type) {
+	case map[string]interface{}:
+		s, ok := sent.(map[string]interface{})
+		b, ok2 := before.(map[string]interface{})
+		if !ok || !ok2 {
+			return now
+		}
+		merged := make(map[string]interface{}, len(n))
+		for key, value := range n {
+			if sentValue, ok := s[key]; ok {
+				merged[key] = mergeDocument(sentValue, b[key], value)
+			} else if !reflect.DeepEqual(value, b[key]) {
+				merged[key] = value // Set by the service
+			}
+		}
+		return merged
+	case []interface{}:
+		s, ok := sent.([]interface{})
+		b, ok2 := before.([]interface{})
+		if !ok || !ok2 || len(s) != len(n) || len(b) != len(n) {
+			return now
+		}
+		merged := make([]interface{}, len(n))
+		for i := range n {
+			merged[i] = mergeDocument(s[i], b[i], n[i])
+		}
+		return merged
+	}
+	return now
+}
+
+// handleSchema publishes reportSchema, identified by its absolute URL
+func handleSchema(w http.ResponseWriter, r *http.Request) {
+	schema := *reportSchema
+	schema.ID = absoluteURL(r, schemaPath)
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(b)
+}
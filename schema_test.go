@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHandleSchema(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleSchema(w, httptest.NewRequest("GET", schemaPath, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/schema+json" {
+		t.Fatalf("GET %s = %d %s", schemaPath, w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var schema JSONSchema
+	if err := json.NewDecoder(w.Body).Decode(&schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.ID != "http://example.com"+schemaPath || !contains(schema.Required, "id") || !contains(schema.Required, "date") {
+		t.Errorf("schema = %+v", schema)
+	}
+	// Set by the service
+	for _, prop := range []string{"seal", "summary"} {
+		if schema.Properties[prop] != nil {
+			t.Errorf("%s is published", prop)
+		}
+	}
+	for _, def := range []string{"Room", "Case", "Item", "Signature", "Unit", "Information", "Report"} {
+		if schema.Defs[def] == nil {
+			t.Errorf("missing $defs/%s", def)
+		}
+	}
+	rooms := schema.Defs["Report"].Properties["rooms"]
+	if rooms == nil || rooms.Items == nil || rooms.Items.Ref != "#/$defs/Room" {
+		t.Errorf("report.rooms = %+v", rooms)
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{
+		"id": 5,
+		"signatures": [{"name": "", "email": "nope", "data_uri": "data:image/png;base64,AA=="}],
+		"report": {"name": "Move in", "rooms": [{"name": "Pantry", "images": [""], "inventory": [{"images": ["/relative.jpg"]}]}]}
+	}`), &doc)
+
+	var errs ValidationErrors
+	reportSchema.validate(reportSchema, doc, "", &errs)
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		"id: must be string, not integer",
+		"date: required",
+		"signatures[0].name: required",
+		"signatures[0].email: must be a valid email",
+		"report.rooms[0].images[0]: required",
+		"report.rooms[0].inventory[0].name: required",
+		"report.rooms[0].inventory[0].images[0]: must be an absolute http or https URL",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("validate() = %q, want %q", got, want)
+	}
+}
+
+func TestValidateSchemaRequest(t *testing.T) {
+	d := NewMemoryDirectory()
+	d.Add("unit-01-02", Information{Name: "Unit 01-02"})
+	units = d
+	defer func() { units = nil }()
+
+	sent := func(edit func(doc map[string]interface{})) InspectionReport {
+		body, _ := json.Marshal(testReport(t))
+		var doc map[string]interface{}
+		json.Unmarshal(body, &doc)
+		edit(doc)
+		body, _ = json.Marshal(doc)
+		ir, err := upgradeReport(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ir
+	}
+
+	// Left out, rather than sent empty
+	ir := sent(func(doc map[string]interface{}) {
+		delete(doc, "date")
+		delete(doc["signatures"].([]interface{})[0].(map[string]interface{}), "name")
+	})
+	errs, err := validateSchema(ir)
+	if got := fmt.Sprint(errs); err != nil || got != "date: required; signatures[0].name: required" {
+		t.Errorf("validateSchema() = %s, %v", got, err)
+	}
+
+	// Filled from the unit directory
+	ir = sent(func(doc map[string]interface{}) {
+		doc["unit"] = map[string]interface{}{"id": "unit-01-02", "information": map[string]interface{}{"city": "San Pedro"}}
+	})
+	if err := resolveUnit(&ir); err != nil {
+		t.Fatal(err)
+	}
+	if errs, err := validateSchema(ir); len(errs) > 0 || err != nil {
+		t.Errorf("validateSchema() of a resolved unit = %v, %v", errs, err)
+	}
+
+	// Ignored without a schema_version
+	ir = sent(func(doc map[string]interface{}) {
+		delete(doc, "schema_version")
+		doc["tenant_ref"] = "T-1"
+	})
+	warnings, err := prepareReport(&ir)
+	if err != nil || len(warnings) == 0 || warnings[0].Error() != "tenant_ref: unknown field, ignored" {
+		t.Errorf("prepareReport() = %v, %v", warnings, err)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gorilla/mux"
+	"github.com/tj/go/http/response"
+)
+
+// Seal proves a report has not been edited since it was issued
+type Seal struct {
+	Hash      string `json:"hash"`      // SHA-256 of the canonical report, the fingerprint
+	Signature string `json:"signature"` // Ed25519 signature of Hash by the server
+	KeyID     string `json:"key_id"`
+}
+
+// SealRecord is kept for every issued artifact under seals/<SHA-256 of the artifact>.json
+type SealRecord struct {
+	ReportID    string    `json:"report_id"`
+	Artifact    string    `json:"artifact"` // html, json or pdf
+	URL         string    `json:"url"`
+	Fingerprint string    `json:"fingerprint"`
+	Signature   string    `json:"signature"`
+	Issued      time.Time `json:"issued"`
+}
+
+// Verification is the answer of POST /verify
+type Verification struct {
+	Issued         bool      `json:"issued"` // Byte-for-byte what was issued
+	SignatureValid bool      `json:"signature_valid"`
+	ReportID       string    `json:"report_id,omitempty"`
+	Artifact       string    `json:"artifact,omitempty"`
+	URL            string    `json:"url,omitempty"`
+	Fingerprint    string    `json:"fingerprint,omitempty"`
+	IssuedAt       time.Time `json:"issued_at,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+}
+
+// sealKey signs the fingerprints, see loadSealKey
+var sealKey ed25519.PrivateKey
+
+// loadSealKey reads the hex encoded Ed25519 seed from SEAL_KEY. Without one, a
+// key lasting until the service restarts is made when ephemeral, for local
+// development, as published seals could no longer be verified otherwise.
+func loadSealKey(seed string, ephemeral bool) (ed25519.PrivateKey, error) {
+	if seed == "" {
+		if !ephemeral {
+			return nil, fmt.Errorf("SEAL_KEY is required unless STORAGE is local or memory")
+		}
+		log.Warn("SEAL_KEY is not set, seals will not verify once the service restarts")
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	b, err := hex.DecodeString(seed)
+	if err != nil {
+		return nil, fmt.Errorf("SEAL_KEY: %v", err)
+	}
+	if len(b) != ed25519.SeedSize {
+		return nil, fmt.Errorf("SEAL_KEY must be %d bytes, got %d", ed25519.SeedSize, len(b))
+	}
+	return ed25519.NewKeyFromSeed(b), nil
+}
+
+func sealKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:4])
+}
+
+// canonicalHash is the SHA-256 of ir without its seal and request options
+func canonicalHash(ir InspectionReport) (string, error) {
+	ir.Seal = nil
+	ir.Force = false
+	ir.Callback = ""
+	b, err := json.Marshal(ir)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func sealReport(ir InspectionReport) (Seal, error) {
+	hash, err := canonicalHash(ir)
+	if err != nil {
+		return Seal{}, err
+	}
+	sum, _ := hex.DecodeString(hash)
+	return Seal{
+		Hash:      hash,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(sealKey, sum)),
+		KeyID:     sealKeyID(sealKey.Public().(ed25519.PublicKey)),
+	}, nil
+}
+
+// checkSeal tells whether signature is ours for fingerprint
+func checkSeal(fingerprint, signature string) bool {
+	sum, err := hex.DecodeString(fingerprint)
+	if err != nil {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(sealKey.Public().(ed25519.PublicKey), sum, sig)
+}
+
+// verifyReport checks the embedded seal still matches the content of ir
+func verifyReport(ir InspectionReport) (fingerprint string, ok bool, err error) {
+	if ir.Seal == nil {
+		return "", false, fmt.Errorf("report is not sealed")
+	}
+	hash, err := canonicalHash(ir)
+	if err != nil {
+		return "", false, err
+	}
+	return hash, hash == ir.Seal.Hash && checkSeal(ir.Seal.Hash, ir.Seal.Signature), nil
+}
+
+func artifactKey(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "seals/" + hex.EncodeToString(sum[:]) + ".json"
+}
+
+// recordSeal remembers body was issued for the sealed report
+func recordSeal(ir InspectionReport, artifact, url string, body []byte) error {
+	rec, err := json.Marshal(SealRecord{
+		ReportID:    ir.ID,
+		Artifact:    artifact,
+		URL:         url,
+		Fingerprint: ir.Seal.Hash,
+		Signature:   ir.Seal.Signature,
+		Issued:      time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return store.Put(artifactKey(body), rec, "application/json; charset=UTF-8", Private)
+}
+
+// verifyArtifact says whether body is an HTML, JSON or PDF we issued
+func verifyArtifact(body []byte) (v Verification, err error) {
+	rec, err := store.Get(artifactKey(body))
+	if err == nil {
+		var r SealRecord
+		if err := json.Unmarshal(rec, &r); err != nil {
+			return v, err
+		}
+		return Verification{
+			Issued:         true,
+			SignatureValid: checkSeal(r.Fingerprint, r.Signature),
+			ReportID:       r.ReportID,
+			Artifact:       r.Artifact,
+			URL:            r.URL,
+			Fingerprint:    r.Fingerprint,
+			IssuedAt:       r.Issued,
+		}, nil
+	}
+	if err != ErrNotFound {
+		return v, err
+	}
+
+	// Not issued as is, but a JSON dump may have only been reformatted
+	var ir InspectionReport
+	if json.Unmarshal(body, &ir) != nil || ir.Seal == nil {
+		v.Reason = "not issued by this service"
+		return v, nil
+	}
+	v.Artifact = "json"
+	v.ReportID = ir.ID
+	fingerprint, ok, err := verifyReport(ir)
+	if err != nil {
+		return v, err
+	}
+	v.Fingerprint = fingerprint
+	v.SignatureValid = ok
+	if ok {
+		v.Reason = "content matches its seal, but the file is not byte-for-byte what was issued"
+	} else {
+		v.Reason = "content was modified after it was sealed"
+	}
+	return v, nil
+}
+
+func handleVerify(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 32<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v, err := verifyArtifact(body)
+	if err != nil {
+		log.WithError(err).Error("verifying artifact")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response.JSON(w, v)
+}
+
+// ReportVerification is the answer of GET /verify/{id}
+type ReportVerification struct {
+	Verified       bool             `json:"verified"`
+	Reason         string           `json:"reason,omitempty"`
+	ReportID       string           `json:"report_id"`
+	Fingerprint    string           `json:"fingerprint,omitempty"` // Recomputed from the stored dump
+	Sealed         bool             `json:"sealed"`
+	Intact         bool             `json:"intact"`          // Fingerprint matches the seal
+	SignatureValid bool             `json:"signature_valid"` // Seal was signed by this service
+	Issued         bool             `json:"issued"`          // Stored dump is byte-for-byte what was issued
+	IssuedAt       time.Time        `json:"issued_at,omitempty"`
+	JSON           string           `json:"json"`
+	HTML           string           `json:"html"`
+	PDF            string           `json:"pdf"`
+	Report         InspectionReport `json:"-"`
+}
+
+// verifyStored recomputes the fingerprint of the stored dump of report id
+func verifyStored(prefix, id string) (v ReportVerification, err error) {
+	key, body, err := findDump(prefix, id)
+	if err != nil {
+		return v, err
+	}
+	var ir InspectionReport
+	if err := json.Unmarshal(body, &ir); err != nil {
+		return v, err
+	}
+
+	v = ReportVerification{ReportID: ir.ID, Report: ir}
+	v.JSON, v.HTML, v.PDF = artifactURLs(key, ir)
+	if ir.Seal == nil {
+		v.Reason = "report was issued before reports were sealed"
+		return v, nil
+	}
+	v.Sealed = true
+	v.Fingerprint, err = canonicalHash(ir)
+	if err != nil {
+		return v, err
+	}
+	v.Intact = v.Fingerprint == ir.Seal.Hash
+	v.SignatureValid = checkSeal(ir.Seal.Hash, ir.Seal.Signature)
+
+	rec, err := store.Get(artifactKey(body))
+	switch err {
+	case nil:
+		var r SealRecord
+		if err := json.Unmarshal(rec, &r); err != nil {
+			return v, err
+		}
+		v.Issued, v.IssuedAt = true, r.Issued
+	case ErrNotFound:
+	default:
+		return v, err
+	}
+
+	v.Verified = v.Intact && v.SignatureValid && v.Issued
+	switch {
+	case !v.Intact:
+		v.Reason = "report content was modified after it was sealed"
+	case !v.SignatureValid:
+		v.Reason = "seal was not signed by this service"
+	case !v.Issued:
+		v.Reason = "stored report is not byte-for-byte what was issued"
+	}
+	return v, nil
+}
+
+func handleVerifyReport(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	prefix := ""
+	if date := r.URL.Query().Get("date"); date != "" {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		prefix = date + "/"
+	}
+
+	v, err := verifyStored(prefix, id)
+	if err == ErrNotFound {
+		response.NotFound(w)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Errorf("verifying %s", id)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		response.JSON(w, v)
+		return
+	}
+	t := template.Must(template.New("").Funcs(templateFuncs).ParseFiles("templates/verify.html"))
+	if err := t.ExecuteTemplate(w, "verify.html", v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	sealKey, err = loadSealKey("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", false)
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestVerifyArtifact(t *testing.T) {
+	mem := NewMemoryStorage("http://localhost/media")
+	store = mem
+	defer func() { store = nil }()
+
+	output, err := genHTML(New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(u string) []byte {
+		b, err := mem.Get(strings.TrimPrefix(u, "http://localhost/media/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	dumpJSON := get(output.JSON)
+	var ir InspectionReport
+	json.Unmarshal(dumpJSON, &ir)
+	if ir.Seal == nil || !strings.Contains(string(get(output.HTML)), ir.Seal.Hash) {
+		t.Fatal("HTML footer is missing the fingerprint")
+	}
+
+	for _, u := range []string{output.HTML, output.JSON, output.PDF} {
+		v, err := verifyArtifact(get(u))
+		if err != nil || !v.Issued || !v.SignatureValid || v.ReportID != output.ID || v.URL != u {
+			t.Errorf("verifyArtifact(%s) = %+v, %v", u, v, err)
+		}
+	}
+
+	reformatted, _ := json.Marshal(ir)
+	v, _ := verifyArtifact(reformatted)
+	if v.Issued || !v.SignatureValid {
+		t.Errorf("reformatted dump = %+v", v)
+	}
+
+	ir.Signatures = append(ir.Signatures, Signature{Name: "Mallory"})
+	tampered, _ := json.Marshal(ir)
+	v, _ = verifyArtifact(tampered)
+	if v.Issued || v.SignatureValid {
+		t.Errorf("tampered dump = %+v", v)
+	}
+
+	v, _ = verifyArtifact([]byte("<html></html>"))
+	if v.Issued || v.Reason == "" {
+		t.Errorf("unknown HTML = %+v", v)
+	}
+}
+
+func TestHandleVerifyReport(t *testing.T) {
+	mem := NewMemoryStorage("http://localhost/media")
+	store = mem
+	defer func() { store = nil }()
+
+	output, err := genHTML(New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := mux.NewRouter()
+	app.HandleFunc("/verify/{id}", handleVerifyReport).Methods("GET")
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/verify/"+output.ID+"?format=json", nil))
+	var v ReportVerification
+	json.NewDecoder(w.Body).Decode(&v)
+	if !v.Verified || v.JSON != output.JSON || v.HTML != output.HTML || v.PDF != output.PDF {
+		t.Errorf("GET /verify/%s = %+v", output.ID, v)
+	}
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/verify/"+output.ID, nil))
+	if !strings.Contains(w.Body.String(), "This report is genuine") {
+		t.Errorf("verification page = %s", w.Body)
+	}
+
+	// Edit the stored dump behind our back
+	key := strings.TrimPrefix(output.JSON, "http://localhost/media/")
+	body, _ := mem.Get(key)
+	mem.Put(key, []byte(strings.Replace(string(body), "Ikea Ivar Shelf", "Ikea Billy Shelf", 1)), "application/json", Public)
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/verify/"+output.ID, nil))
+	if !strings.Contains(w.Body.String(), "content was modified") {
+		t.Errorf("verification page of edited report = %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/verify/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /verify/missing = %d", w.Code)
+	}
+}
+
+// Fields added to structs.go must be omitempty, or reports sealed before no longer verify
+func TestCanonicalHashOfEarlierReports(t *testing.T) {
+	b, _ := json.Marshal(Item{Name: "Pantry cabinet"})
+	if want := `{"name":"Pantry cabinet","images":null,"description":""}`; string(b) != want {
+		t.Errorf("Item = %s, want %s as before item cases", b, want)
+	}
+}
+
+func TestLoadSealKey(t *testing.T) {
+	if _, err := loadSealKey("", false); err == nil {
+		t.Error("loadSealKey() without SEAL_KEY = nil, want an error outside local development")
+	}
+	if key, err := loadSealKey("", true); err != nil || len(key) == 0 {
+		t.Errorf("loadSealKey() ephemeral = %v, want a key", err)
+	}
+	if _, err := loadSealKey("0001", false); err == nil {
+		t.Error("loadSealKey() short seed = nil, want an error")
+	}
+}
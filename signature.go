@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/png"
+	"strings"
+)
+
+// Limits of a signature image. Signature pads draw a few hundred pixels wide,
+// anything much larger is a photo or an attempt to bloat the report.
+const (
+	maxSignatureBytes = 512 << 10 // Decoded
+	maxSignatureSide  = 2000      // In pixels, as received
+	signatureSide     = 600       // Longest side kept, in pixels
+	signatureMargin   = 4         // Pixels kept around the ink when trimming
+)
+
+// signatureTypes are the MIME types a signature may be sent as
+var signatureTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true}
+
+// signatureData checks the data URI of a signature as far as its header tells,
+// without decoding the image, returning its bytes. Problems that do not stop
+// the signature from being read are returned as notes.
+func signatureData(uri string) (data []byte, notes []string, err error) {
+	mimeType, data, err := decodeDataURI(strings.TrimSpace(uri))
+	switch {
+	case err != nil:
+		return nil, nil, fmt.Errorf("must be a base64 data URI: %v", err)
+	case !strings.HasPrefix(mimeType, "image/"):
+		return nil, nil, fmt.Errorf("must be an image, not %q", mimeType)
+	case !signatureTypes[mimeType]:
+		return nil, nil, fmt.Errorf("must be a PNG, JPEG or GIF image, not %q", mimeType)
+	case len(data) == 0:
+		return nil, nil, fmt.Errorf("empty image")
+	case len(data) > maxSignatureBytes:
+		return nil, nil, fmt.Errorf("is %d bytes, at most %d allowed", len(data), maxSignatureBytes)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot be decoded: %v", err)
+	}
+	if cfg.Width > maxSignatureSide || cfg.Height > maxSignatureSide {
+		return nil, nil, fmt.Errorf("is %dx%d pixels, at most %dx%d allowed", cfg.Width, cfg.Height, maxSignatureSide, maxSignatureSide)
+	}
+	if "image/"+format != mimeType {
+		notes = append(notes, fmt.Sprintf("sent as %s but is a %s image", mimeType, format))
+	}
+	return data, notes, nil
+}
+
+// normaliseSignature decodes the data URI of a signature, trims the blank
+// space around the ink and recompresses it as a PNG data URI, see signatureData
+func normaliseSignature(uri string) (normalised string, notes []string, err error) {
+	data, notes, err := signatureData(uri)
+	if err != nil {
+		return "", nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot be decoded: %v", err)
+	}
+
+	crop, ok := inkBounds(img)
+	if !ok {
+		return "", nil, fmt.Errorf("is blank")
+	}
+	w, h := crop.Dx(), crop.Dy()
+	if w > signatureSide || h > signatureSide {
+		if w >= h {
+			w, h = signatureSide, (h*signatureSide+w/2)/w
+		} else {
+			w, h = (w*signatureSide+h/2)/h, signatureSide
+		}
+	}
+
+	var b bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(&b, resample(img, crop, w, h)); err != nil {
+		return "", nil, err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(b.Bytes()), notes, nil
+}
+
+// inkBounds is the area of img drawn on, with signatureMargin around it, false
+// when nothing is. Very flat strokes keep some height, as they are drawn to a
+// fixed height in reports.
+func inkBounds(img image.Image) (image.Rectangle, bool) {
+	b := img.Bounds()
+	ink := image.Rectangle{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if isInk(img, x, y) {
+				ink = ink.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if ink.Empty() {
+		return ink, false
+	}
+	ink = ink.Inset(-signatureMargin)
+	if min := ink.Dx() / 4; ink.Dy() < min {
+		grow := (min - ink.Dy() + 1) / 2
+		ink.Min.Y, ink.Max.Y = ink.Min.Y-grow, ink.Max.Y+grow
+	}
+	return ink.Intersect(b), true
+}
+
+// isInk tells a pixel drawn on from the transparent or white background of a pad
+func isInk(img image.Image, x, y int) bool {
+	r, g, b, a := img.At(x, y).RGBA()
+	if a < 0x1000 {
+		return false
+	}
+	// Premultiplied, so near white is close to the alpha
+	light := a * 15 / 16
+	return r < light || g < light || b < light
+}
+
+// signatureProblem is what is wrong with the data URI of a signature, empty
+// when nothing. The image is not decoded, so signatures are checked again
+// cheaply once normalised, which tells the blank ones.
+func signatureProblem(uri string) string {
+	if _, _, err := signatureData(uri); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// normaliseSignatures replaces the signatures of ir by their normalised
+// version, returning what was noticed along the way as warnings. Reports are
+// normalised once, when received, before they are validated.
+func normaliseSignatures(ir *InspectionReport) (warnings []FieldError, err error) {
+	var v ValidationErrors
+	for i, s := range ir.Signatures {
+		if s.DataURI == "" {
+			continue
+		}
+		field := fmt.Sprintf("signatures[%d].data_uri", i)
+		uri, notes, err := normaliseSignature(string(s.DataURI))
+		if err != nil {
+			v.add(field, "%s", err)
+			continue
+		}
+		ir.Signatures[i].DataURI = template.URL(uri)
+		for _, note := range notes {
+			warnings = append(warnings, FieldError{Field: field, Message: note})
+		}
+	}
+	if len(v) > 0 {
+		return warnings, v
+	}
+	return warnings, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// padSignature is a white w x h pad with a zigzag drawn from (x0, y) to (x1, y)
+func padSignature(w, h, x0, x1, y int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for x := x0; x < x1; x++ {
+		for dy := -3; dy <= 3; dy++ {
+			img.Set(x, y+dy+(x%20)-10, color.Black)
+		}
+	}
+	return img
+}
+
+func dataURI(t *testing.T, mimeType string, img image.Image) string {
+	var b bytes.Buffer
+	var err error
+	if mimeType == "image/jpeg" {
+		err = jpeg.Encode(&b, img, nil)
+	} else {
+		err = png.Encode(&b, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(b.Bytes())
+}
+
+func TestNormaliseSignature(t *testing.T) {
+	uri, notes, err := normaliseSignature(" " + dataURI(t, "image/png", padSignature(300, 150, 100, 200, 75)) + "\n")
+	if err != nil || len(notes) != 0 {
+		t.Fatalf("normaliseSignature() = %v, %v", notes, err)
+	}
+	mimeType, data, _ := decodeDataURI(uri)
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if mimeType != "image/png" || err != nil {
+		t.Fatalf("normalised to %s, %v", mimeType, err)
+	}
+	// The stroke, 100x26, within a margin of 4
+	if cfg.Width != 108 || cfg.Height != 34 {
+		t.Errorf("trimmed to %dx%d, want 108x34", cfg.Width, cfg.Height)
+	}
+
+	_, notes, err = normaliseSignature(dataURI(t, "image/jpeg", padSignature(300, 150, 10, 290, 75)))
+	if err != nil || len(notes) != 0 {
+		t.Errorf("JPEG signature = %v, %v", notes, err)
+	}
+	mislabelled := strings.Replace(dataURI(t, "image/jpeg", padSignature(300, 150, 10, 290, 75)), "image/jpeg", "image/png", 1)
+	if _, notes, err = normaliseSignature(mislabelled); err != nil || len(notes) != 1 || notes[0] != "sent as image/png but is a jpeg image" {
+		t.Errorf("mislabelled signature = %q, %v", notes, err)
+	}
+
+	uri, _, err = normaliseSignature(dataURI(t, "image/png", padSignature(1600, 800, 0, 1600, 400)))
+	_, data, _ = decodeDataURI(uri)
+	if cfg, _ = png.DecodeConfig(bytes.NewReader(data)); err != nil || cfg.Width != signatureSide {
+		t.Errorf("large signature = %dx%d, %v", cfg.Width, cfg.Height, err)
+	}
+}
+
+func TestSignatureProblem(t *testing.T) {
+	blank := image.NewRGBA(image.Rect(0, 0, 300, 150))
+	for _, tt := range []struct {
+		name, uri, want string
+	}{
+		{"script", "javascript:alert(1)", "must be a base64 data URI: not a data URI"},
+		{"svg", "data:image/svg+xml;base64,PHN2Zy8+", `must be a PNG, JPEG or GIF image, not "image/svg+xml"`},
+		{"garbage", "data:image/png;base64,AA==", "cannot be decoded: image: unknown format"},
+		{"too wide", dataURI(t, "image/png", image.NewGray(image.Rect(0, 0, 2001, 10))), "is 2001x10 pixels, at most 2000x2000 allowed"},
+		{"too heavy", "data:image/png;base64," + strings.Repeat("AAAA", maxSignatureBytes/3+1), "is 524289 bytes, at most 524288 allowed"},
+	} {
+		if got := signatureProblem(tt.uri); got != tt.want {
+			t.Errorf("%s: signatureProblem() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	// Only told once decoded
+	if _, _, err := normaliseSignature(dataURI(t, "image/png", blank)); err == nil || err.Error() != "is blank" {
+		t.Errorf("normaliseSignature() blank = %v, want is blank", err)
+	}
+}
+
+func TestSignatureWarnings(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+
+	ir := testReport(t)
+	mislabelled := strings.Replace(dataURI(t, "image/jpeg", padSignature(300, 150, 10, 290, 75)), "image/jpeg", "image/png", 1)
+	ir.Signatures[1].DataURI = template.URL(mislabelled)
+	warnings, err := prepareReport(&ir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FieldError{Field: "signatures[1].data_uri", Message: "sent as image/png but is a jpeg image"}
+	if len(warnings) != 1 || warnings[0] != want {
+		t.Errorf("Warnings = %+v, want %+v", warnings, want)
+	}
+	output, err := genHTML(ir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, _, err := findReport("", output.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range saved.Signatures {
+		if !strings.HasPrefix(string(s.DataURI), "data:image/png;base64,") || len(s.DataURI) >= len(mislabelled) {
+			t.Errorf("signature of %s was not normalised: %.40s", s.Name, s.DataURI)
+		}
+	}
+}
@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/tj/go/http/response"
+)
+
+// Draft states, as reported by GET /drafts/{id}
+const (
+	DraftPending = "pending" // Waiting for signatures
+	DraftSigned  = "signed"  // Everyone signed, the report was queued for rendering
+	DraftExpired = "expired" // The signing links expired before everyone signed
+)
+
+// signingTTL is how long signing links work
+const signingTTL = 7 * 24 * time.Hour
+
+// requeueAfter is how long a signed report may wait for its job to show up,
+// as the instance which queued it may be another one, or have restarted
+const requeueAfter = 15 * time.Minute
+
+// Draft is a report waiting for the signatures of its Signers, stored under drafts/
+type Draft struct {
+	ID      string           `json:"id"`
+	Report  InspectionReport `json:"report"`
+	Signers []Signer         `json:"signers"` // Of Report.Signatures, in the same order
+	Created time.Time        `json:"created"`
+	Expires time.Time        `json:"expires"`
+	Queued  time.Time        `json:"queued,omitempty"` // When the signed report was last queued
+	JobID   string           `json:"job_id,omitempty"` // Rendering the signed report
+	Output  *responseHTML    `json:"output,omitempty"` // Of the job, once it succeeded
+	// Of the signatures sent with the draft, see normaliseSignatures
+	Warnings []FieldError `json:"warnings,omitempty"`
+	Error    string       `json:"error,omitempty"` // Why it could not be queued, or rendered
+}
+
+// Signer is the state of the signing link of a Signature
+type Signer struct {
+	TokenHash string    `json:"token_hash,omitempty"` // SHA-256 of the secret of the link, which is not stored
+	Signed    time.Time `json:"signed,omitempty"`
+}
+
+// Status is the state of the draft at now
+func (d Draft) Status(now time.Time) string {
+	for _, s := range d.Signers {
+		if s.Signed.IsZero() {
+			if now.After(d.Expires) {
+				return DraftExpired
+			}
+			return DraftPending
+		}
+	}
+	return DraftSigned
+}
+
+// needsQueueing tells whether the signed report of d must be queued at now:
+// it never was, or its job was not stored as the instance queueing it stopped.
+func (d Draft) needsQueueing(now time.Time) bool {
+	if d.Status(now) != DraftSigned || d.Output != nil {
+		return false
+	}
+	if d.JobID != "" {
+		if d.Error != "" {
+			return false // Rendering failed
+		}
+		if jobs != nil {
+			if _, err := jobs.Get(d.JobID); err == nil {
+				return false
+			}
+		}
+	} else if d.Error != "" {
+		return true
+	}
+	return now.Sub(d.Queued) > requeueAfter
+}
+
+func draftKey(id string) string {
+	return "drafts/" + id + ".json"
+}
+
+var draftIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func loadDraft(id string) (d Draft, err error) {
+	if !draftIDRe.MatchString(id) {
+		return d, ErrNotFound
+	}
+	body, err := store.Get(draftKey(id))
+	if err != nil {
+		return d, err
+	}
+	err = json.Unmarshal(body, &d)
+	return d, err
+}
+
+// saveDraft stores the new draft d
+func saveDraft(d Draft) error {
+	return putDraft(d, "")
+}
+
+func putDraft(d Draft, version string) error {
+	body, err := json.MarshalIndent(d, "", "    ")
+	if err != nil {
+		return err
+	}
+	return store.PutIf(draftKey(d.ID), body, "application/json; charset=UTF-8", Private, version)
+}
+
+// errUnchanged is returned by the function given to updateDraft to leave the draft as it is
+var errUnchanged = errors.New("unchanged")
+
+// draftAttempts is how many times updateDraft tries to write a draft others are updating
+const draftAttempts = 5
+
+// updateDraft applies f to the stored draft id, reloading it and applying f
+// again when someone else updated it meanwhile, as several people sign at once
+// and the service may run on several instances. The draft is returned as
+// stored.
+func updateDraft(id string, f func(*Draft) error) (Draft, error) {
+	if !draftIDRe.MatchString(id) {
+		return Draft{}, ErrNotFound
+	}
+	for i := 0; i < draftAttempts; i++ {
+		var d Draft
+		body, version, err := store.GetVersion(draftKey(id))
+		if err != nil {
+			return d, err
+		}
+		if err := json.Unmarshal(body, &d); err != nil {
+			return d, err
+		}
+		if err := f(&d); err == errUnchanged {
+			return d, nil
+		} else if err != nil {
+			return d, err
+		}
+		if err := putDraft(d, version); err != ErrConflict {
+			return d, err
+		}
+	}
+	return Draft{}, fmt.Errorf("updating draft %s: %v", id, ErrConflict)
+}
+
+// queueDraft stores a job rendering the signed report of the draft id when it
+// needs one, see runDraft. The draft records it was queued before the job is
+// stored, so only one of those calling it at once does.
+func queueDraft(id string, now time.Time) (Draft, error) {
+	queued := false
+	d, err := updateDraft(id, func(d *Draft) error {
+		if !d.needsQueueing(now) {
+			return errUnchanged
+		}
+		d.Queued, queued = now, true
+		return nil
+	})
+	if err != nil || !queued {
+		return d, err
+	}
+	d.submit()
+	return updateDraft(id, func(latest *Draft) error {
+		if !latest.Queued.Equal(d.Queued) || (d.JobID != "" && latest.JobID == d.JobID) {
+			return errUnchanged // Queued again, or the job already finished
+		}
+		latest.JobID, latest.Error = d.JobID, d.Error
+		return nil
+	})
+}
+
+// runDraft renders the signed report of d when its job is pending, as the
+// instance may be frozen once it replied. The draft is returned as the job
+// left it. A job lost meanwhile is queued again by queueDraft.
+func runDraft(d Draft) (Draft, error) {
+	if d.JobID == "" || d.Output != nil || d.Error != "" {
+		return d, nil
+	}
+	if _, err := jobs.Run(d.JobID); err == ErrNotFound {
+		return d, nil
+	} else if err != nil {
+		return d, err
+	}
+	return loadDraft(d.ID)
+}
+
+// resumeDrafts queues the signed reports whose job was never stored, as the
+// instance queueing them stopped, for jobQueue.Resume to render
+func resumeDrafts() error {
+	keys, err := store.List("drafts/")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, key := range keys {
+		id := strings.TrimSuffix(strings.TrimPrefix(key, "drafts/"), ".json")
+		if _, err := queueDraft(id, now); err != nil {
+			log.WithError(err).Warnf("queueing draft %s", id)
+		}
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// signer finds the signature whose link has token
+func (d Draft) signer(token string) (int, bool) {
+	hash := hashToken(token)
+	for i, s := range d.Signers {
+		if s.TokenHash != "" && subtle.ConstantTimeCompare([]byte(s.TokenHash), []byte(hash)) == 1 {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// validateDraft is validateReport, except for the signatures still to be collected
+func validateDraft(ir InspectionReport) error {
+	err := validateReport(ir)
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		return err
+	}
+	var left ValidationErrors
+	for _, e := range errs {
+		var i int
+		if _, err := fmt.Sscanf(e.Field, "signatures[%d].data_uri", &i); err == nil && ir.Signatures[i].DataURI == "" {
+			continue
+		}
+		left = append(left, e)
+	}
+	if len(left) > 0 {
+		return left
+	}
+	return nil
+}
+
+// signingURL is the absolute link of a signer, on SIGNING_URL or the host of r
+func signingURL(r *http.Request, draftID, token string) string {
+	base := os.Getenv("SIGNING_URL")
+	if base == "" {
+		base = absoluteURL(r, "/sign")
+	}
+	return strings.TrimSuffix(base, "/") + "/" + draftID + "/" + token
+}
+
+// DraftSigner is a Signature of a draft as the API shows it
+type DraftSigner struct {
+	Name   string     `json:"name"`
+	Role   string     `json:"role"`
+	Email  string     `json:"email,omitempty"`
+	Link   string     `json:"link,omitempty"` // Only when the draft is created
+	Signed *time.Time `json:"signed,omitempty"`
+}
+
+// DraftStatus is the answer of POST /drafts and GET /drafts/{id}
+type DraftStatus struct {
+	ID       string        `json:"id"`
+	ReportID string        `json:"report_id"`
+	Status   string        `json:"status"`
+	Expires  time.Time     `json:"expires"`
+	Signers  []DraftSigner `json:"signers"`
+	Job      *Job          `json:"job,omitempty"`
+	Output   *responseHTML `json:"output,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func draftStatus(d Draft, links []string) DraftStatus {
+	status := DraftStatus{ID: d.ID, ReportID: d.Report.ID, Status: d.Status(time.Now()), Expires: d.Expires, Output: d.Output, Error: d.Error}
+	for i, s := range d.Report.Signatures {
+		signer := DraftSigner{Name: s.Name, Role: s.Role, Email: s.Email}
+		if links != nil {
+			signer.Link = links[i]
+		}
+		if signed := d.Signers[i].Signed; !signed.IsZero() {
+			signer.Signed = &signed
+		}
+		status.Signers = append(status.Signers, signer)
+	}
+	if d.JobID != "" && jobs != nil {
+		if job, err := jobs.Get(d.JobID); err == nil {
+			status.Job = &job
+		}
+	}
+	return status
+}
+
+// handleDraftCreate stores a report whose signatures have no data_uri yet,
+// replying with a signing link for each of them
+func handleDraftCreate(w http.ResponseWriter, r *http.Request) {
+	ir, err := decodeReport(r)
+	if err != nil {
+		decodeFailed(w, err)
+		return
+	}
+	if err := resolveUnit(&ir); err != nil {
+		resolveFailed(w, err)
+		return
+	}
+	warnings, err := normaliseSignatures(&ir)
+	if err != nil {
+		validationFailed(w, err)
+		return
+	}
+	if err := validateDraft(ir); err != nil {
+		validationFailed(w, err)
+		return
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	d := Draft{ID: id, Report: ir, Created: now, Expires: now.Add(signingTTL), Warnings: warnings}
+	links := make([]string, len(ir.Signatures))
+	for i, s := range ir.Signatures {
+		if s.DataURI != "" {
+			// Signed up front, e.g. by the creator
+			d.Signers = append(d.Signers, Signer{Signed: now})
+			continue
+		}
+		token, err := randomHex(16)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d.Signers = append(d.Signers, Signer{TokenHash: hashToken(token)})
+		links[i] = signingURL(r, id, token)
+	}
+	if err := saveDraft(d); err != nil {
+		log.WithError(err).Error("saving draft")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if d.Status(now) == DraftSigned {
+		if d, err = queueDraft(id, now); err == nil {
+			d, err = runDraft(d)
+		}
+		if err != nil {
+			log.WithError(err).Errorf("rendering draft %s", id)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	log.Infof("Draft %s of %s waits for %d signatures", id, ir.ID, len(ir.Signatures))
+	response.JSON(w, draftStatus(d, links), http.StatusCreated)
+}
+
+// handleDraftStatus describes the draft {id}, first rendering its signed report
+// when its job is pending or was lost, as the instance which queued it may have
+// been stopped
+func handleDraftStatus(w http.ResponseWriter, r *http.Request) {
+	d, err := queueDraft(mux.Vars(r)["id"], time.Now())
+	if err == nil {
+		d, err = runDraft(d)
+	}
+	if err == ErrNotFound {
+		response.NotFound(w)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("rendering draft")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response.JSON(w, draftStatus(d, nil))
+}
+
+// signPage is what templates/sign.html shows a signer
+type signPage struct {
+	Draft     Draft
+	Signature Signature
+	Summary   *Summary
+	Consent   string // What the signer agrees to, recorded with the signature
+	Preview   string // Path of the report as it will be issued
+	Problem   string // Why the signature cannot be taken, or was rejected
+	Done      bool   // Just signed
+	CanSign   bool   // Shows the signature pad
+	CSRFField template.HTML
+}
+
+// newSignPage shows d to its signer i
+func newSignPage(r *http.Request, d Draft, i int) signPage {
+	page := signPage{Draft: d, Signature: d.Report.Signatures[i], Summary: summarise(d.Report.Report)}
+	page.Consent = consentStatement(d.Report, page.Signature)
+	page.Preview = r.URL.Path + "/preview"
+	return page
+}
+
+func renderSignPage(w http.ResponseWriter, r *http.Request, status int, page signPage) {
+	page.CSRFField = csrf.TemplateField(r)
+	t, err := template.New("").Funcs(templateFuncs).ParseFiles("templates/sign.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Robots-Tag", "none")
+	w.Header().Set("Referrer-Policy", "no-referrer") // The link is the credential
+	w.WriteHeader(status)
+	if err := t.ExecuteTemplate(w, "sign.html", page); err != nil {
+		log.WithError(err).Error("rendering sign.html")
+	}
+}
+
+// signerOf loads the draft and signer of the link of r, replying when there is none
+func signerOf(w http.ResponseWriter, r *http.Request) (d Draft, i int, ok bool) {
+	vars := mux.Vars(r)
+	d, err := loadDraft(vars["draft"])
+	if err == nil {
+		if i, ok = d.signer(vars["token"]); ok {
+			return d, i, true
+		}
+	}
+	if err != nil && err != ErrNotFound {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return d, 0, false
+	}
+	renderSignPage(w, r, http.StatusNotFound, signPage{Problem: "This signing link is not valid."})
+	return d, 0, false
+}
+
+// closedProblem tells why signer i of d can no longer sign
+func closedProblem(d Draft, i int) (status int, problem string) {
+	switch {
+	case !d.Signers[i].Signed.IsZero():
+		return http.StatusConflict, "You already signed this report."
+	case time.Now().After(d.Expires):
+		return http.StatusGone, "This signing link has expired, ask for a new one."
+	}
+	return 0, ""
+}
+
+// handleSignPage shows the report to the signer of the link, with a signature pad
+func handleSignPage(w http.ResponseWriter, r *http.Request) {
+	d, i, ok := signerOf(w, r)
+	if !ok {
+		return
+	}
+	page := newSignPage(r, d, i)
+	status, problem := closedProblem(d, i)
+	if problem != "" {
+		page.Problem = problem
+		renderSignPage(w, r, status, page)
+		return
+	}
+	page.CanSign = true
+	renderSignPage(w, r, http.StatusOK, page)
+}
+
+// handleSignPreview renders the report of the link with its template, as it
+// will be issued, for the signer to review before signing
+func handleSignPreview(w http.ResponseWriter, r *http.Request) {
+	d, _, ok := signerOf(w, r)
+	if !ok {
+		return
+	}
+	ir := d.Report
+	structureInventory(&ir)
+	ir.Summary = summarise(ir.Report)
+	t, _, err := loadTemplate(ir.Template)
+	if err != nil {
+		log.WithError(err).Errorf("loading the template of draft %s", d.ID)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, ir); err != nil {
+		log.WithError(err).Errorf("previewing draft %s", d.ID)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("X-Robots-Tag", "none")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	w.Header().Set("Content-Security-Policy", "sandbox") // Templates may be fetched
+	w.Write(b.Bytes())
+}
+
+// handleSign records the signature posted from the signing page, rendering
+// the report once everyone has signed
+func handleSign(w http.ResponseWriter, r *http.Request) {
+	d, i, ok := signerOf(w, r)
+	if !ok {
+		return
+	}
+	page := newSignPage(r, d, i)
+	if status, problem := closedProblem(d, i); problem != "" {
+		page.Problem = problem
+		renderSignPage(w, r, status, page)
+		return
+	}
+
+	uri, _, err := normaliseSignature(r.PostFormValue("data_uri"))
+	if err != nil {
+		page.Problem = "Your signature could not be read, please sign again: " + err.Error()
+		page.CanSign = true
+		renderSignPage(w, r, http.StatusUnprocessableEntity, page)
+		return
+	}
+
+	now := time.Now()
+	audit := auditRequest(r, now, page.Consent)
+	var status int
+	var problem string
+	d, err = updateDraft(d.ID, func(d *Draft) error {
+		// Signed or expired meanwhile
+		if status, problem = closedProblem(*d, i); problem != "" {
+			return errUnchanged
+		}
+		d.Report.Signatures[i].DataURI = template.URL(uri)
+		d.Report.Signatures[i].Audit = audit
+		d.Signers[i].Signed = now
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Errorf("saving draft %s", page.Draft.ID)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if problem != "" {
+		page.Problem = problem
+		renderSignPage(w, r, status, page)
+		return
+	}
+	if d.Status(now) == DraftSigned {
+		// A failure is retried by GET /drafts/{id}, the signature is saved
+		queued, err := queueDraft(d.ID, now)
+		if err == nil {
+			queued, err = runDraft(queued)
+		}
+		if err != nil {
+			log.WithError(err).Errorf("rendering draft %s", d.ID)
+		} else {
+			d = queued
+		}
+	}
+	log.Infof("%s signed draft %s", d.Report.Signatures[i].Name, d.ID)
+	page.Draft, page.Signature, page.Done = d, d.Report.Signatures[i], true
+	renderSignPage(w, r, http.StatusOK, page)
+}
+
+// submit stores a job rendering the signed report, recording it or why it failed
+func (d *Draft) submit() {
+	ir := d.Report
+	if err := validateReport(ir); err != nil {
+		d.JobID, d.Error = "", err.Error()
+		return
+	}
+	job, err := newJob(jobRecord{Report: &ir, Warnings: d.Warnings, Draft: d.ID, DraftQueued: d.Queued})
+	if err != nil {
+		log.WithError(err).Errorf("submitting draft %s", d.ID)
+		d.JobID, d.Error = "", err.Error()
+		return
+	}
+	d.JobID, d.Error = job.ID, ""
+}
+
+// recordDraftJob records the finished job in the draft id whose signed report
+// it rendered, as queued at queued, since the job is forgotten after jobTTL
+func recordDraftJob(id string, queued time.Time, job Job) {
+	_, err := updateDraft(id, func(d *Draft) error {
+		if !d.Queued.Equal(queued) {
+			return errUnchanged // Queued again meanwhile
+		}
+		d.JobID, d.Output, d.Error = job.ID, job.Output, job.Error
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Errorf("recording job %s of draft %s", job.ID, id)
+	}
+}
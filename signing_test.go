@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRemoteSigning(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+	rendered := make(chan InspectionReport, 1)
+	jobs = newJobQueue(1, 10, func(ir InspectionReport) (responseHTML, error) {
+		rendered <- ir
+		return responseHTML{ID: ir.ID}, nil
+	})
+	defer func() { jobs = nil }()
+
+	app := mux.NewRouter()
+	app.HandleFunc("/drafts", handleDraftCreate).Methods("POST")
+	app.HandleFunc("/drafts/{id}", handleDraftStatus).Methods("GET")
+	app.HandleFunc("/sign/{draft}/{token}", handleSignPage).Methods("GET")
+	app.HandleFunc("/sign/{draft}/{token}", handleSign).Methods("POST")
+	app.HandleFunc("/sign/{draft}/{token}/preview", handleSignPreview).Methods("GET")
+
+	ir := testReport(t)
+	signature := ir.Signatures[0].DataURI
+	for i := range ir.Signatures {
+		ir.Signatures[i].DataURI = ""
+	}
+	body, _ := json.Marshal(ir)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("POST", "http://pdfgen.example.com/drafts", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /drafts = %d %s", w.Code, w.Body)
+	}
+	var draft DraftStatus
+	json.NewDecoder(w.Body).Decode(&draft)
+	if draft.Status != DraftPending || len(draft.Signers) != 2 {
+		t.Fatalf("draft = %+v", draft)
+	}
+	links := make([]string, 2)
+	for i, s := range draft.Signers {
+		if !strings.HasPrefix(s.Link, "http://pdfgen.example.com/sign/"+draft.ID+"/") {
+			t.Fatalf("link of %s = %s", s.Name, s.Link)
+		}
+		links[i] = strings.TrimPrefix(s.Link, "http://pdfgen.example.com")
+	}
+
+	sign := func(link, uri string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		form := neturl.Values{"data_uri": {uri}, "latitude": {"1.3521"}, "longitude": {"103.8198"}}
+		req := httptest.NewRequest("POST", link, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", "signing-test")
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", links[1], nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<strong>I, Ng (Tenant), have reviewed") {
+		t.Errorf("GET %s = %d %s", links[1], w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `<iframe src="`+links[1]+`/preview"`) {
+		t.Errorf("GET %s does not show the report", links[1])
+	}
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", links[1]+"/preview", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Pantry") {
+		t.Errorf("GET %s/preview = %d %.200s", links[1], w.Code, w.Body)
+	}
+
+	if w := sign(links[0], "data:text/plain;base64,aGk="); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("signing with text = %d", w.Code)
+	}
+	if w := sign(links[0], string(signature)); w.Code != http.StatusOK {
+		t.Fatalf("signing = %d %s", w.Code, w.Body)
+	}
+	if w := sign(links[0], string(signature)); w.Code != http.StatusConflict {
+		t.Errorf("signing twice = %d", w.Code)
+	}
+	if w := sign("/sign/"+draft.ID+"/0123", string(signature)); w.Code != http.StatusNotFound {
+		t.Errorf("signing with a wrong token = %d", w.Code)
+	}
+	select {
+	case <-rendered:
+		t.Fatal("rendered before everyone signed")
+	default:
+	}
+
+	if w := sign(links[1], string(signature)); w.Code != http.StatusOK {
+		t.Fatalf("signing = %d %s", w.Code, w.Body)
+	}
+	normalised, _, _ := normaliseSignature(string(signature))
+	select {
+	case got := <-rendered:
+		for _, s := range got.Signatures {
+			if string(s.DataURI) != normalised {
+				t.Errorf("signature of %s was not collected", s.Name)
+			}
+			if a := s.Audit; a == nil || a.IP != "192.0.2.1" || a.UserAgent != "signing-test" ||
+				a.Location == nil || !strings.HasPrefix(a.Consent, "I, "+s.Name) {
+				t.Errorf("audit of %s = %+v", s.Name, a)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not rendered once everyone signed")
+	}
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/drafts/"+draft.ID, nil))
+	var signed DraftStatus
+	json.NewDecoder(w.Body).Decode(&signed)
+	if signed.Status != DraftSigned || signed.Job == nil || signed.Signers[1].Signed == nil || signed.Signers[1].Link != "" {
+		t.Errorf("draft = %+v", signed)
+	}
+	// Rendered before the last signer got a reply
+	if signed.Output == nil || signed.Job.Status != JobSucceeded {
+		t.Errorf("draft output = %+v, job = %+v", signed.Output, signed.Job)
+	}
+}
+
+func TestSigningLinkExpires(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+
+	d := Draft{ID: strings.Repeat("ab", 16), Report: testReport(t), Expires: time.Now().Add(-time.Hour)}
+	d.Signers = []Signer{{TokenHash: hashToken("secret")}, {TokenHash: hashToken("other")}}
+	if err := saveDraft(d); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Status(time.Now()); got != DraftExpired {
+		t.Errorf("Status() = %s, want %s", got, DraftExpired)
+	}
+
+	app := mux.NewRouter()
+	app.HandleFunc("/sign/{draft}/{token}", handleSignPage).Methods("GET")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/sign/"+d.ID+"/secret", nil))
+	if w.Code != http.StatusGone || strings.Contains(w.Body.String(), "<canvas>") {
+		t.Errorf("GET expired link = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestLostJobIsQueuedAgain(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+	rendered := make(chan InspectionReport, 2)
+	jobs = newJobQueue(1, 10, func(ir InspectionReport) (responseHTML, error) {
+		rendered <- ir
+		return responseHTML{ID: ir.ID}, nil
+	})
+	defer func() { jobs = nil }()
+
+	// Signed and queued by an instance which restarted since
+	now := time.Now()
+	d := Draft{ID: strings.Repeat("ab", 16), Report: testReport(t), Expires: now.Add(time.Hour)}
+	d.Signers = []Signer{{Signed: now}, {Signed: now}}
+	d.JobID, d.Queued = "lost", now
+	if err := saveDraft(d); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := queueDraft(d.ID, now); err != nil || got.JobID != "lost" {
+		t.Errorf("queueDraft() = %s, %v, want the job waited for", got.JobID, err)
+	}
+
+	app := mux.NewRouter()
+	app.HandleFunc("/drafts/{id}", handleDraftStatus).Methods("GET")
+	d.Queued = now.Add(-requeueAfter - time.Minute)
+	if _, err := updateDraft(d.ID, func(stored *Draft) error { stored.Queued = d.Queued; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/drafts/"+d.ID, nil))
+	var status DraftStatus
+	json.NewDecoder(w.Body).Decode(&status)
+	if w.Code != http.StatusOK || status.Job == nil {
+		t.Fatalf("GET /drafts/%s = %d %+v, want queued again", d.ID, w.Code, status)
+	}
+	select {
+	case <-rendered:
+	default:
+		t.Fatal("not rendered once queued again")
+	}
+
+	// Once rendered, the output is kept with the draft
+	saved, err := loadDraft(d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Output == nil || saved.needsQueueing(time.Now().Add(jobTTL)) {
+		t.Errorf("rendered draft = %+v", saved)
+	}
+}
+
+func TestResumeDrafts(t *testing.T) {
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+	rendered := make(chan InspectionReport, 2)
+	jobs = newJobQueue(1, 10, func(ir InspectionReport) (responseHTML, error) {
+		rendered <- ir
+		return responseHTML{ID: ir.ID}, nil
+	})
+	defer func() { jobs = nil }()
+
+	// Signed by an instance which stopped before storing the job
+	now := time.Now()
+	d := Draft{ID: strings.Repeat("cd", 16), Report: testReport(t), Expires: now.Add(time.Hour)}
+	d.Signers = []Signer{{Signed: now}, {Signed: now}}
+	d.Queued = now.Add(-requeueAfter - time.Minute)
+	if err := saveDraft(d); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resumeDrafts(); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-rendered:
+	case <-time.After(time.Second):
+		t.Fatal("not rendered once resumed")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		saved, err := loadDraft(d.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Output != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("output not recorded in the draft")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestValidateDraft(t *testing.T) {
+	ir := testReport(t)
+	ir.Signatures[1].DataURI = ""
+	if err := validateDraft(ir); err != nil {
+		t.Errorf("validateDraft() = %v, want unsigned signatures allowed", err)
+	}
+	ir.Signatures[1].Name = ""
+	if err := validateDraft(ir); err == nil || err.Error() != "signatures[1].name: required" {
+		t.Errorf("validateDraft() = %v, want the name of the signer required", err)
+	}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ErrNotFound is returned by a Storage when the key does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned by PutIf when the object changed since it was read
+var ErrConflict = errors.New("changed since it was read")
+
+// Visibility is who may read a stored object
+type Visibility int
+
+const (
+	Public  Visibility = iota // Anyone with its URL, e.g. the artifacts of a report
+	Private                   // Only this service, e.g. drafts, seal records and templates
+)
+
+// Storage is where the generated artifacts (HTML, JSON dumps) are kept
+type Storage interface {
+	Put(key string, body []byte, contentType string, v Visibility) error
+	Get(key string) ([]byte, error)
+	// GetVersion is Get with an opaque version of the object, for PutIf
+	GetVersion(key string) (body []byte, version string, err error)
+	// PutIf is Put when key still has version, or does not exist when version
+	// is empty, returning ErrConflict otherwise. Updates by several instances
+	// of the service at once go through it.
+	PutIf(key string, body []byte, contentType string, v Visibility, version string) error
+	List(prefix string) ([]string, error)
+	Delete(key string) error
+	URL(key string) string
+}
+
+// store is the configured Storage, see newStorage
+var store Storage
+
+// newStorage picks a backend from STORAGE: "s3" (default), "local" or "memory"
+func newStorage(kind string) (Storage, error) {
+	switch kind {
+	case "", "s3":
+		return S3Storage{
+			Svc:    s3.New(e.Cfg),
+			Bucket: e.Bucket("media"),
+			Domain: e.Udomain("media"),
+		}, nil
+	case "local":
+		dir := os.Getenv("STORAGE_DIR")
+		if dir == "" {
+			dir = "output"
+		}
+		return LocalStorage{Dir: dir, BaseURL: storageURL()}, nil
+	case "memory":
+		return NewMemoryStorage(storageURL()), nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE %q, expected s3, local or memory", kind)
+	}
+}
+
+// findReport loads the JSON dump of report id, written by genHTML under a dated
+// key. prefix narrows the search, e.g. to "2018-08-20/".
+func findReport(prefix, id string) (ir InspectionReport, key string, err error) {
+	key, body, err := findDump(prefix, id)
+	if err != nil {
+		return ir, key, err
+	}
+	ir, err = upgradeReport(body)
+	return ir, key, err
+}
+
+// findDump is the key and raw content of the JSON dump of report id, on the
+// day of prefix when given, else where its index entry points to
+func findDump(prefix, id string) (key string, body []byte, err error) {
+	if prefix != "" {
+		key = prefix + id + ".json"
+		body, err = store.Get(key)
+		return key, body, err
+	}
+	key, err = indexedDump(id)
+	if err == ErrNotFound && !dumpsIndexed() {
+		key, err = scanDumps(id)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	body, err = store.Get(key)
+	return key, body, err
+}
+
+// dumpKeyRe matches the keys of the JSON dumps written by genHTML
+var dumpKeyRe = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})/([^/]+)\.json$`)
+
+// indexKey is where dump records the key of the latest dump of report id, as
+// report IDs do not tell the day their dump is stored under
+func indexKey(id string) string {
+	return "index/" + id + ".json"
+}
+
+// indexDoneKey marks the dumps stored before the index was as indexed
+const indexDoneKey = "index/complete"
+
+type indexEntry struct {
+	Key string `json:"key"`
+}
+
+func indexedDump(id string) (string, error) {
+	body, err := store.Get(indexKey(id))
+	if err != nil {
+		return "", err
+	}
+	var entry indexEntry
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return "", err
+	}
+	return entry.Key, nil
+}
+
+// indexDump records key as the latest dump of report id. Only a missing entry
+// is written unless replace, so a backfill never undoes a newer dump.
+func indexDump(id, key string, replace bool) error {
+	body, err := json.Marshal(indexEntry{Key: key})
+	if err != nil {
+		return err
+	}
+	if replace {
+		return store.Put(indexKey(id), body, "application/json", Private)
+	}
+	err = store.PutIf(indexKey(id), body, "application/json", Private, "")
+	if err == ErrConflict {
+		return nil
+	}
+	return err
+}
+
+func dumpsIndexed() bool {
+	_, err := store.Get(indexDoneKey)
+	return err == nil
+}
+
+// scanDumps finds the latest dump of report id by listing every dated key,
+// as long as indexDumps has not indexed the dumps stored before the index
+func scanDumps(id string) (key string, err error) {
+	keys, err := store.List("")
+	if err != nil {
+		return "", err
+	}
+	var candidates []string
+	for _, k := range keys {
+		if m := dumpKeyRe.FindStringSubmatch(k); m != nil && m[2] == id {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", ErrNotFound
+	}
+	if key, err = latestDump(candidates); err != nil {
+		return "", err
+	}
+	return key, indexDump(id, key, false)
+}
+
+// latestDump is the last issued of the dumps of a report at keys. Their day is
+// that of the inspection, which a regeneration may have moved either way.
+func latestDump(keys []string) (latest string, err error) {
+	var at time.Time
+	for _, k := range keys {
+		issued, err := dumpIssued(k)
+		if err != nil {
+			return "", err
+		}
+		if latest == "" || !issued.Before(at) {
+			latest, at = k, issued
+		}
+	}
+	return latest, nil
+}
+
+// dumpIssued is when the dump at key was issued, according to its SealRecord,
+// or the day of its key for the dumps stored before they were sealed
+func dumpIssued(key string) (time.Time, error) {
+	body, err := store.Get(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	rec, err := store.Get(artifactKey(body))
+	if err == ErrNotFound {
+		return time.Parse("2006-01-02", dumpKeyRe.FindStringSubmatch(key)[1])
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	var r SealRecord
+	if err := json.Unmarshal(rec, &r); err != nil {
+		return time.Time{}, err
+	}
+	return r.Issued, nil
+}
+
+// indexDumps indexes the dumps stored before the index, once per storage
+func indexDumps() error {
+	if dumpsIndexed() {
+		return nil
+	}
+	keys, err := store.List("")
+	if err != nil {
+		return err
+	}
+	dumps := make(map[string][]string) // Report ID to keys
+	for _, k := range keys {
+		if m := dumpKeyRe.FindStringSubmatch(k); m != nil {
+			dumps[m[2]] = append(dumps[m[2]], k)
+		}
+	}
+	for id, candidates := range dumps {
+		key, err := latestDump(candidates)
+		if err != nil {
+			return err
+		}
+		if err := indexDump(id, key, false); err != nil {
+			return err
+		}
+	}
+	log.Infof("Indexed %d report dumps", len(dumps))
+	return store.Put(indexDoneKey, []byte(time.Now().Format(time.RFC3339)), "text/plain", Private)
+}
+
+// storageURL is where non S3 artifacts are served from, see handleMedia
+func storageURL() string {
+	if u := os.Getenv("STORAGE_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return fmt.Sprintf("http://localhost:%s/media", os.Getenv("PORT"))
+}
+
+// S3Storage publishes artifacts as public-read objects in the media bucket
+type S3Storage struct {
+	Svc    *s3.S3
+	Bucket string
+	Domain string // e.g. media.dev.unee-t.com
+}
+
+// Put uploads body to key, readable by anyone when v is Public
+func (s S3Storage) Put(key string, body []byte, contentType string, v Visibility) error {
+	acl := s3.ObjectCannedACLPublicRead
+	if v == Private {
+		acl = s3.ObjectCannedACLPrivate
+	}
+	req := s.Svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Body:        bytes.NewReader(body),
+		Key:         aws.String(key),
+		ACL:         acl,
+		ContentType: aws.String(contentType),
+	})
+	_, err := req.Send()
+	return err
+}
+
+// Get downloads key
+func (s S3Storage) Get(key string) ([]byte, error) {
+	body, _, err := s.GetVersion(key)
+	return body, err
+}
+
+// GetVersion downloads key, its version is the ETag
+func (s S3Storage) GetVersion(key string) ([]byte, string, error) {
+	req := s.Svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	out, err := req.Send()
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", err
+	}
+	defer out.Body.Close()
+	body, err := ioutil.ReadAll(out.Body)
+	return body, aws.StringValue(out.ETag), err
+}
+
+// PutIf uploads body with If-Match, or If-None-Match when creating, which S3
+// checks atomically. This SDK predates them, so the headers are set by hand.
+func (s S3Storage) PutIf(key string, body []byte, contentType string, v Visibility, version string) error {
+	req := s.Svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Body:        bytes.NewReader(body),
+		Key:         aws.String(key),
+		ACL:         s3.ObjectCannedACLPrivate,
+		ContentType: aws.String(contentType),
+	})
+	if v == Public {
+		req.Input.ACL = s3.ObjectCannedACLPublicRead
+	}
+	req.Handlers.Build.PushBack(func(r *aws.Request) {
+		if version == "" {
+			r.HTTPRequest.Header.Set("If-None-Match", "*")
+		} else {
+			r.HTTPRequest.Header.Set("If-Match", version)
+		}
+	})
+	_, err := req.Send()
+	if rerr, ok := err.(awserr.RequestFailure); ok &&
+		(rerr.StatusCode() == http.StatusPreconditionFailed || rerr.StatusCode() == http.StatusConflict) {
+		return ErrConflict
+	}
+	return err
+}
+
+// List returns the keys starting with prefix
+func (s S3Storage) List(prefix string) (keys []string, err error) {
+	req := s.Svc.ListObjectsV2Request(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	p := req.Paginate()
+	for p.Next() {
+		for _, obj := range p.CurrentPage().Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+	}
+	return keys, p.Err()
+}
+
+// Delete removes key
+func (s S3Storage) Delete(key string) error {
+	req := s.Svc.DeleteObjectRequest(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	_, err := req.Send()
+	return err
+}
+
+// URL is the public CDN address of key
+func (s S3Storage) URL(key string) string {
+	return fmt.Sprintf("https://%s/%s", s.Domain, key)
+}
+
+// LocalStorage keeps artifacts on disk, for local development and on-prem installs
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func (s LocalStorage) path(key string) (string, error) {
+	p := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(s.Dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return p, nil
+}
+
+// Put writes body to key, creating the dated directory as needed. Private
+// objects are only readable by their owner, which Public checks.
+func (s LocalStorage) Put(key string, body []byte, contentType string, v Visibility) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if v == Private {
+		mode = 0600
+	}
+	if err := ioutil.WriteFile(p, body, mode); err != nil {
+		return err
+	}
+	return os.Chmod(p, mode) // Whatever the umask
+}
+
+// localMu makes PutIf of LocalStorage atomic, for one instance of the service
+var localMu sync.Mutex
+
+// GetVersion reads key, its version is the SHA-256 of its content
+func (s LocalStorage) GetVersion(key string) ([]byte, string, error) {
+	body, err := s.Get(key)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	return body, hex.EncodeToString(sum[:]), nil
+}
+
+// PutIf writes body to key if its content did not change
+func (s LocalStorage) PutIf(key string, body []byte, contentType string, v Visibility, version string) error {
+	localMu.Lock()
+	defer localMu.Unlock()
+	_, current, err := s.GetVersion(key)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if current != version {
+		return ErrConflict
+	}
+	return s.Put(key, body, contentType, v)
+}
+
+// Public tells whether handleMedia may serve key
+func (s LocalStorage) Public(key string) bool {
+	p, err := s.path(key)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(p)
+	return err == nil && info.Mode().Perm()&0004 != 0
+}
+
+// Get reads key
+func (s LocalStorage) Get(key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// List returns the keys starting with prefix
+func (s LocalStorage) List(prefix string) (keys []string, err error) {
+	err = filepath.Walk(s.Dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return keys, err
+}
+
+// Delete removes key
+func (s LocalStorage) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// URL is where handleMedia serves key
+func (s LocalStorage) URL(key string) string {
+	return s.BaseURL + "/" + key
+}
+
+// MemoryStorage keeps artifacts in process, for tests
+type MemoryStorage struct {
+	BaseURL string
+	mu      sync.RWMutex
+	objects map[string][]byte
+	private map[string]bool
+	version map[string]int // Of each key, incremented by every Put
+}
+
+// NewMemoryStorage returns an empty MemoryStorage
+func NewMemoryStorage(baseURL string) *MemoryStorage {
+	return &MemoryStorage{
+		BaseURL: baseURL,
+		objects: make(map[string][]byte),
+		private: make(map[string]bool),
+		version: make(map[string]int),
+	}
+}
+
+// Put stores a copy of body under key
+func (s *MemoryStorage) Put(key string, body []byte, contentType string, v Visibility) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, body, v)
+	return nil
+}
+
+// put stores body, callers hold s.mu
+func (s *MemoryStorage) put(key string, body []byte, v Visibility) {
+	s.objects[key] = append([]byte(nil), body...)
+	s.private[key] = v == Private
+	s.version[key]++
+}
+
+// GetVersion returns a copy of key and how many times it was written
+func (s *MemoryStorage) GetVersion(key string) ([]byte, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	body, ok := s.objects[key]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+	return append([]byte(nil), body...), strconv.Itoa(s.version[key]), nil
+}
+
+// PutIf stores a copy of body under key if it was not written since version
+func (s *MemoryStorage) PutIf(key string, body []byte, contentType string, v Visibility, version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := ""
+	if _, ok := s.objects[key]; ok {
+		current = strconv.Itoa(s.version[key])
+	}
+	if current != version {
+		return ErrConflict
+	}
+	s.put(key, body, v)
+	return nil
+}
+
+// Public tells whether handleMedia may serve key
+func (s *MemoryStorage) Public(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.objects[key]
+	return ok && !s.private[key]
+}
+
+// Get returns a copy of key
+func (s *MemoryStorage) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	body, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), body...), nil
+}
+
+// List returns the keys starting with prefix in lexical order
+func (s *MemoryStorage) List(prefix string) (keys []string, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Delete removes key
+func (s *MemoryStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.objects, key)
+	delete(s.private, key)
+	delete(s.version, key)
+	return nil
+}
+
+// URL is where handleMedia serves key
+func (s *MemoryStorage) URL(key string) string {
+	return s.BaseURL + "/" + key
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testStorage(t *testing.T, s Storage) {
+	if err := s.Put("2018-08-20/a.json", []byte(`{"id":"a"}`), "application/json", Public); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := s.Put("2018-08-21/b.html", []byte("<p>b</p>"), "text/html", Public); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := s.Get("2018-08-20/a.json")
+	if err != nil || string(got) != `{"id":"a"}` {
+		t.Errorf("Get() = %q, %v", got, err)
+	}
+	if _, err := s.Get("2018-08-20/missing.json"); err != ErrNotFound {
+		t.Errorf("Get() missing error = %v, want ErrNotFound", err)
+	}
+
+	keys, err := s.List("2018-08-2")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if want := []string{"2018-08-20/a.json", "2018-08-21/b.html"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() = %v, want %v", keys, want)
+	}
+
+	if err := s.Delete("2018-08-20/a.json"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+	if _, err := s.Get("2018-08-20/a.json"); err != ErrNotFound {
+		t.Errorf("Get() after Delete error = %v, want ErrNotFound", err)
+	}
+
+	if u := s.URL("2018-08-21/b.html"); !strings.HasSuffix(u, "/media/2018-08-21/b.html") {
+		t.Errorf("URL() = %s", u)
+	}
+
+	if err := s.PutIf("drafts/c.json", []byte("1"), "application/json", Private, ""); err != nil {
+		t.Fatalf("PutIf() new error = %v", err)
+	}
+	if err := s.PutIf("drafts/c.json", []byte("1"), "application/json", Private, ""); err != ErrConflict {
+		t.Errorf("PutIf() existing error = %v, want ErrConflict", err)
+	}
+	_, version, err := s.GetVersion("drafts/c.json")
+	if err != nil {
+		t.Fatalf("GetVersion() error = %v", err)
+	}
+	if err := s.PutIf("drafts/c.json", []byte("2"), "application/json", Private, version); err != nil {
+		t.Errorf("PutIf() error = %v", err)
+	}
+	if err := s.PutIf("drafts/c.json", []byte("3"), "application/json", Private, version); err != ErrConflict {
+		t.Errorf("PutIf() stale error = %v, want ErrConflict", err)
+	}
+	if got, _ := s.Get("drafts/c.json"); string(got) != "2" {
+		t.Errorf("Get() after PutIf = %q, want 2", got)
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorage(t, NewMemoryStorage("http://localhost/media"))
+}
+
+func TestLocalStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := LocalStorage{Dir: dir, BaseURL: "http://localhost/media"}
+	testStorage(t, s)
+
+	if err := s.Put("../escape.html", nil, "text/html", Public); err == nil {
+		t.Error("Put() outside of Dir should fail")
+	}
+}
+
+func TestGenHTMLMemoryStorage(t *testing.T) {
+	mem := NewMemoryStorage("http://localhost/media")
+	store = mem
+	defer func() { store = nil }()
+
+	ir := New()
+	ir.Date = time.Date(2018, 8, 20, 10, 0, 0, 0, time.UTC)
+	output, err := genHTML(ir)
+	if err != nil {
+		t.Fatalf("genHTML() error = %v", err)
+	}
+	for _, u := range []string{output.HTML, output.JSON, output.PDF} {
+		key := strings.TrimPrefix(u, "http://localhost/media/")
+		if _, err := mem.Get(key); err != nil {
+			t.Errorf("%s not stored: %v", key, err)
+		}
+		// Under the day of the inspection, where signoff.html links to the PDF
+		if !strings.HasPrefix(key, "2018-08-20/") {
+			t.Errorf("%s not stored under the day of the inspection", key)
+		}
+	}
+}
+
+func TestFindReport(t *testing.T) {
+	mem := NewMemoryStorage("http://localhost/media")
+	store = mem
+	defer func() { store = nil }()
+
+	// Stored before dumps were indexed
+	mem.Put("2018-08-20/old.json", []byte(`{"id":"old"}`), "application/json", Public)
+	mem.Put("2018-08-21/older.json", []byte(`{"id":"older"}`), "application/json", Public)
+	if _, key, err := findReport("", "old"); err != nil || key != "2018-08-20/old.json" {
+		t.Errorf("findReport() legacy = %s, %v", key, err)
+	}
+	if err := indexDumps(); err != nil {
+		t.Fatal(err)
+	}
+	if _, key, err := findReport("", "older"); err != nil || key != "2018-08-21/older.json" {
+		t.Errorf("findReport() backfilled = %s, %v", key, err)
+	}
+
+	// Regenerated with an earlier inspection date, the latest issued wins
+	issue := func(key, body string, at time.Time) {
+		mem.Put(key, []byte(body), "application/json", Public)
+		rec, _ := json.Marshal(SealRecord{ReportID: "moved", Artifact: "json", Issued: at})
+		mem.Put(artifactKey([]byte(body)), rec, "application/json", Private)
+	}
+	issue("2018-08-25/moved.json", `{"id":"moved","name":"first"}`, time.Date(2018, 8, 25, 0, 0, 0, 0, time.UTC))
+	issue("2018-08-23/moved.json", `{"id":"moved","name":"second"}`, time.Date(2018, 8, 26, 0, 0, 0, 0, time.UTC))
+	if key, err := scanDumps("moved"); err != nil || key != "2018-08-23/moved.json" {
+		t.Errorf("scanDumps() = %s, %v", key, err)
+	}
+	if err := indexDump("moved", "2018-08-25/moved.json", false); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := indexedDump("moved"); err != nil || key != "2018-08-23/moved.json" {
+		t.Errorf("indexDump() replaced %s without replace, %v", key, err)
+	}
+
+	url, _, err := dump(time.Now(), "new", map[string]string{"id": "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, key, err := findReport("", "new"); err != nil || mem.URL(key) != url {
+		t.Errorf("findReport() = %s, %v, want %s", key, err, url)
+	}
+
+	// Once indexed, the bucket is no longer listed
+	mem.Put("2018-08-22/unindexed.json", []byte(`{}`), "application/json", Public)
+	if _, _, err := findReport("", "unindexed"); err != ErrNotFound {
+		t.Errorf("findReport() unindexed error = %v, want ErrNotFound", err)
+	}
+	if _, key, err := findReport("2018-08-22/", "unindexed"); err != nil || key != "2018-08-22/unindexed.json" {
+		t.Errorf("findReport() on a day = %s, %v", key, err)
+	}
+}
+
+func TestHandleMediaPrivate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { store = nil }()
+
+	for _, s := range []Storage{NewMemoryStorage("http://localhost/media"), LocalStorage{Dir: dir, BaseURL: "http://localhost/media"}} {
+		store = s
+		s.Put("2018-08-20/a.html", []byte("<p>a</p>"), "text/html", Public)
+		s.Put("seals/0123.json", []byte(`{"id":"0123"}`), "application/json", Private)
+		for key, want := range map[string]int{"2018-08-20/a.html": http.StatusOK, "seals/0123.json": http.StatusNotFound} {
+			w := httptest.NewRecorder()
+			handleMedia(w, httptest.NewRequest("GET", "/media/"+key, nil))
+			if w.Code != want {
+				t.Errorf("%T: GET /media/%s = %d, want %d", s, key, w.Code, want)
+			}
+		}
+		if body, err := s.Get("seals/0123.json"); err != nil || len(body) == 0 {
+			t.Errorf("%T: private object not readable by the service: %v", s, err)
+		}
+	}
+}
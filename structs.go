@@ -7,24 +7,31 @@ import (
 
 // Signature holds the wet signature
 type Signature struct {
-	Name    string       `json:"name"` // Who
-	Role    string       `json:"role"`
-	Email   string       `json:"email"`    // Needs to match Creator in order to be consider the report was created by
-	DataURI template.URL `json:"data_uri"` // What: Graphic signature
+	Name    string          `json:"name" jsonschema:"required"` // Who
+	Role    string          `json:"role"`
+	Email   string          `json:"email" jsonschema:"email"`       // Matched against Report.Creator, see creator.go
+	DataURI template.URL    `json:"data_uri" jsonschema:"required"` // What: Graphic signature
+	Audit   *SignatureAudit `json:"audit,omitempty"`                // When, from where and to what, see audit.go
 }
 
 // Case summarises the cases
 type Case struct {
-	Title    string   `json:"title"`
-	Images   []string `json:"images"`
+	Title    string   `json:"title" jsonschema:"required"`
+	Images   []string `json:"images" jsonschema:"url"`
 	Category string   `json:"category"`
 	Status   string   `json:"status"`
 	Details  string   `json:"details"`
+	// Follow-up, see actions.go
+	Priority      string  `json:"priority,omitempty" jsonschema:"enum=urgent|high|normal|low"`
+	Assignee      string  `json:"assignee,omitempty"` // Role of the signature responsible, e.g. Tenant
+	Due           string  `json:"due,omitempty" jsonschema:"date"`
+	EstimatedCost float64 `json:"estimated_cost,omitempty" jsonschema:"minimum=0"`
+	Bug           int     `json:"bug,omitempty"` // Bugzilla bug ID, set when imported, see bugzilla.go
 }
 
 // Information pertaining to the Unit
-type Information struct { // How to see object in Mongo ?
-	Name        string `json:"name"`
+type Information struct {
+	Name        string `json:"name" jsonschema:"required"`
 	Type        string `json:"type"`
 	Address     string `json:"address"`
 	Postcode    string `json:"postcode"`
@@ -36,23 +43,28 @@ type Information struct { // How to see object in Mongo ?
 
 // Unit is actually a Product in Bugzilla
 type Unit struct {
-	Information Information `json:"information"` // Stored in Mongo
+	ID          string      `json:"id,omitempty" jsonschema:"id"` // Fills blank Information from the unit directory, see units.go
+	Information Information `json:"information"`                  // Stored in Mongo
 }
 
 // Item is part of an Inventory
 type Item struct {
-	Name        string   `json:"name"`
-	Images      []string `json:"images"`
+	Name        string   `json:"name" jsonschema:"required"`
+	Images      []string `json:"images" jsonschema:"url"`
 	Description string   `json:"description"`
-	// Not needed right now
-	// Cases       []Case // TODO: not sure what this looks like in the published report
+	Cases       []Case   `json:"cases,omitempty"` // Issues with this item, e.g. a repair of a chipped table
+	Quantity    int      `json:"quantity,omitempty" jsonschema:"minimum=0"`
+	Condition   string   `json:"condition,omitempty" jsonschema:"enum=excellent|good|fair|poor|damaged"` // See condition.go
+	Brand       string   `json:"brand,omitempty"`
+	Serial      string   `json:"serial,omitempty"`
 }
 
 // Report for the Unit and rooms of the unit
 type Report struct {
-	Name        string   `json:"name"` // Handover of unit – 20 Maple Avenue, Unit 01-02
+	Name        string   `json:"name" jsonschema:"required"`           // Handover of unit – 20 Maple Avenue, Unit 01-02
+	Creator     string   `json:"creator,omitempty" jsonschema:"email"` // Email of the signature of whoever created the report, the first when empty
 	Description string   `json:"description"`
-	Images      []string `json:"images"`
+	Images      []string `json:"images" jsonschema:"url"`
 	Cases       []Case   `json:"cases"`
 	Inventory   []Item   `json:"inventory"`
 	Rooms       []Room   `json:"rooms"`
@@ -61,21 +73,166 @@ type Report struct {
 
 // Room each can have issues (cases) and an inventory
 type Room struct {
-	Name        string   `json:"name"`
+	Name        string   `json:"name" jsonschema:"required"`
 	Description string   `json:"description"`
-	Images      []string `json:"images"`
+	Images      []string `json:"images" jsonschema:"url"`
 	Cases       []Case   `json:"cases"`
 	Inventory   []Item   `json:"inventory"`
 }
 
+// CaseCount is the number of cases in the room, including those of its inventory
+func (r Room) CaseCount() int {
+	n := len(r.Cases)
+	for _, item := range r.Inventory {
+		n += len(item.Cases)
+	}
+	return n
+}
+
 // InspectionReport is the top level structure that holds a report
 type InspectionReport struct {
-	ID         string      `json:"id"`
-	Logo       string      `json:"logo"`
-	Date       time.Time   `json:"date"`
-	Signatures []Signature `json:"signatures"`
-	Unit       Unit        `json:"unit"`
-	Report     Report      `json:"report"`
-	Template   string      `json:"template"`
-	Force      bool        `json:"force"`
+	SchemaVersion int          `json:"schema_version,omitempty"` // See migrate.go, missing in dumps written before it
+	ID            string       `json:"id" jsonschema:"required,id"`
+	Logo          string       `json:"logo" jsonschema:"url"`
+	Date          time.Time    `json:"date" jsonschema:"required"`
+	Signatures    []Signature  `json:"signatures" jsonschema:"minItems=1,maxItems=12"` // Few enough to fit a page, see Report.Creator
+	Unit          Unit         `json:"unit"`
+	Report        Report       `json:"report"`
+	Template      string       `json:"template"`
+	Force         bool         `json:"force"`
+	Render        string       `json:"render,omitempty" jsonschema:"enum=link|embed|copy"` // How images are included, see embed.go
+	Callback      string       `json:"callback,omitempty" jsonschema:"url"`                // Notified by POST when rendering finishes or fails
+	Bugzilla      *BugzillaRef `json:"bugzilla,omitempty"`                                 // Open bugs to import as cases, see bugzilla.go
+	Seal          *Seal        `json:"seal,omitempty" jsonschema:"-"`                      // Set by genHTML, see seal.go
+	Summary       *Summary     `json:"summary,omitempty" jsonschema:"-"`                   // Set by genHTML, see summary.go
+
+	doc map[string]interface{} // As decoded by upgradeReport, see validateSchema
+}
+
+// New returns an example InspectionReport, used as the defaults for the signature pad form
+func New() InspectionReport {
+	return InspectionReport{
+		ID:         "12345678",
+		Date:       time.Now(),
+		Signatures: nil,
+		Unit: Unit{
+			Information: Information{
+				Name:        "Unit 01-02",
+				Type:        "Apartment/Flat",
+				Address:     "20 Maple Avenue",
+				Postcode:    "90731",
+				City:        "San Pedro",
+				State:       "California",
+				Country:     "USA",
+				Description: "Blue house with a front porch. Parking is not allowed in the driveway",
+			},
+		},
+		Report: Report{
+			Name: "20 Maple Avenue, Unit 01-02",
+			Images: []string{
+				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/table_succulent.jpg",
+				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_7126.jpg",
+				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/table_succulent.jpg",
+				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_7126.jpg",
+				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/table_succulent.jpg",
+				"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_7126.jpg",
+			},
+			Cases: []Case{{
+				Title: "Cracks on Ceiling",
+				Images: []string{
+					"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/inspection_report.jpg",
+				},
+				Category: "Reference",
+				Status:   "Confirmed",
+				Details:  "Worse over time and rain is sometimes seen to be leaking when it rains.",
+			}},
+			Inventory: []Item{{
+				Name:        "Ikea Ivar Shelf",
+				Images:      []string{"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/images.jpg"},
+				Description: "1 in acceptable condition",
+			},
+			},
+			Rooms: []Room{
+				{
+					Name:        "Big Meeting Room",
+					Description: "300 sqft with built-in cabinets, air-con and WiFi",
+					Images:      nil,
+					Cases: []Case{
+						{
+							Title:    "Light is not working",
+							Images:   []string{"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_9411.jpg", "http://res.cloudinary.com/unee-t-staging/image/upload/e_cartoonify/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_9411.jpg"},
+							Category: "Repair",
+							Status:   "Confirmed",
+							Details:  "Lights are unable to turn on after change the light bulb",
+						},
+						{
+							Title:    "Floor stain and the mould seems to smell",
+							Images:   []string{"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/wood_floor_stain.jpg"},
+							Category: "Complex project",
+							Status:   "Reopened",
+							Details:  "Horrible floor statins are appearing due to moisture over time. There is a bad smell.",
+						},
+					},
+					Inventory: nil,
+				},
+				{
+					Name:        "Pantry",
+					Description: "800 sqft, high with built-in cabinets, air-con and WiFi",
+					Images:      []string{"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/pantry.jpg"},
+					Cases:       nil,
+					Inventory: []Item{
+						{
+							Name:        "LG Electronics fridge",
+							Images:      []string{"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/pantry_fridge.jpg"},
+							Description: "Door seal replaced last year",
+							Quantity:    1,
+							Condition:   ConditionFair,
+							Brand:       "LG",
+							Serial:      "GR-B247WL-0917",
+						},
+						{
+							Name:        "Solid Wood long table",
+							Images:      []string{"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/pantry_02.jpg"},
+							Description: "1 in very bad condition. Table is baldy chipped and edges are wearing out.",
+							Cases: []Case{{
+								Title:    "Chipped table top",
+								Category: "Repair",
+								Status:   "Confirmed",
+								Details:  "Edges to be sanded and varnished before the next tenant moves in.",
+								Priority: "normal",
+								Due:      "2018-09-01",
+							}},
+						},
+						{
+							Name:        "Pantry cabinet",
+							Images:      []string{"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/pantry_microwave.jpg"},
+							Description: "1 in good condition. Well maintained.",
+						},
+						{
+							Name:        "Bekant chairs",
+							Images:      []string{"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0522.jpg", "https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0519.jpg"},
+							Description: "12 in mint condition.",
+						},
+						{
+							Name:        "More chairs",
+							Images:      []string{"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0522.jpg", "https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0519.jpg"},
+							Description: "12 in mint condition.",
+						},
+						{
+							Name: "So many more chairs",
+							Images: []string{
+								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0522.jpg",
+								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0519.jpg",
+								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0519.jpg",
+								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0522.jpg",
+								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0522.jpg",
+								"https://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_500,w_500/v1534218648/Unee-T%20inspection%20report%20-%20placeholder%20images/IMG_0519.jpg"},
+							Description: "6 in mint condition.",
+						},
+					},
+				},
+			},
+			Comments: "A comment pertaining to the report itself.",
+		},
+	}
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoomCaseCount(t *testing.T) {
+	pantry := New().Report.Rooms[1]
+	if got, want := pantry.CaseCount(), len(pantry.Cases)+1; got != want {
+		t.Errorf("CaseCount() = %d, want %d", got, want)
+	}
+}
+
+func TestHandlePostItemCases(t *testing.T) {
+	mem := NewMemoryStorage("http://localhost/media")
+	store = mem
+	defer func() { store = nil }()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for k, v := range map[string]string{
+		"Signatures.0.Name":                         "Kai",
+		"Signatures.0.dataURI":                      string(testReport(t).Signatures[0].DataURI),
+		"Report.Rooms.0.Inventory.0.Name":           "Projector",
+		"Report.Rooms.0.Inventory.0.Cases.0.Title":  "Lamp flickers",
+		"Report.Rooms.0.Inventory.0.Cases.0.Status": "Open",
+	} {
+		form.WriteField(k, v)
+	}
+	form.Close()
+
+	r := httptest.NewRequest("POST", "/htmlgen", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	handlePost(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /htmlgen = %d %s", w.Code, w.Body)
+	}
+
+	var output responseHTML
+	json.NewDecoder(w.Body).Decode(&output)
+	html, err := mem.Get(strings.TrimPrefix(output.HTML, "http://localhost/media/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Lamp flickers", "Chipped table top", "GR-B247WL-0917", "Condition: 30 excellent", formConsent(New())} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("%q not rendered", want)
+		}
+	}
+}
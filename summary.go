@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Summary is the overview at the top of a report, computed by genHTML
+type Summary struct {
+	Cases                int              `json:"cases"` // Of the unit, its rooms and their inventory
+	CasesByCategory      []Tally          `json:"cases_by_category"`
+	CasesByStatus        []Tally          `json:"cases_by_status"`
+	OpenCases            int              `json:"open_cases"`
+	InventoryItems       int              `json:"inventory_items"` // Sum of quantities, an item without one counts as one
+	Conditions           []ConditionCount `json:"conditions"`
+	ItemsInPoorCondition int              `json:"items_in_poor_condition"` // Poor or damaged
+	RoomsWithOpenIssues  []string         `json:"rooms_with_open_issues"`
+}
+
+// Tally is how many cases share a Category or Status
+type Tally struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// closedStatuses are the Bugzilla statuses of cases that need nothing more
+var closedStatuses = []string{"resolved", "verified", "closed", "fixed", "done"}
+
+// Open tells whether the case still needs work
+func (c Case) Open() bool {
+	return !contains(closedStatuses, strings.ToLower(strings.TrimSpace(c.Status)))
+}
+
+func tally(counts map[string]int) (t []Tally) {
+	for name, n := range counts {
+		t = append(t, Tally{Name: name, Count: n})
+	}
+	// Most frequent first
+	sort.Slice(t, func(i, j int) bool {
+		if t[i].Count != t[j].Count {
+			return t[i].Count > t[j].Count
+		}
+		return t[i].Name < t[j].Name
+	})
+	return t
+}
+
+// summarise computes the Summary of the report
+func summarise(r Report) *Summary {
+	s := &Summary{}
+	byCategory := make(map[string]int)
+	byStatus := make(map[string]int)
+	addCases := func(cases []Case) (open bool) {
+		for _, c := range cases {
+			s.Cases++
+			byCategory[orUnset(c.Category)]++
+			byStatus[orUnset(c.Status)]++
+			if c.Open() {
+				s.OpenCases++
+				open = true
+			}
+		}
+		return open
+	}
+	addInventory := func(items []Item) (open bool) {
+		for _, item := range items {
+			if addCases(item.Cases) {
+				open = true
+			}
+		}
+		return open
+	}
+
+	addCases(r.Cases)
+	addInventory(r.Inventory)
+	inventory := append([]Item(nil), r.Inventory...)
+	for _, room := range r.Rooms {
+		open := addCases(room.Cases)
+		if addInventory(room.Inventory) {
+			open = true
+		}
+		if open {
+			s.RoomsWithOpenIssues = append(s.RoomsWithOpenIssues, room.Name)
+		}
+		inventory = append(inventory, room.Inventory...)
+	}
+
+	s.CasesByCategory = tally(byCategory)
+	s.CasesByStatus = tally(byStatus)
+	s.Conditions = summariseConditions(inventory)
+	for _, c := range s.Conditions {
+		s.InventoryItems += c.Quantity
+		if c.Condition == ConditionPoor || c.Condition == ConditionDamaged {
+			s.ItemsInPoorCondition += c.Quantity
+		}
+	}
+	return s
+}
+
+func orUnset(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "Unset"
+	}
+	return s
+}
+
+// tallies is a Tally as text, e.g. "Confirmed 3, Open 1"
+func tallies(t []Tally) string {
+	parts := make([]string, len(t))
+	for i, c := range t {
+		parts[i] = c.Name + " " + strconv.Itoa(c.Count)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ByCategory is CasesByCategory as text, for templates
+func (s Summary) ByCategory() string { return tallies(s.CasesByCategory) }
+
+// ByStatus is CasesByStatus as text, for templates
+func (s Summary) ByStatus() string { return tallies(s.CasesByStatus) }
+
+// Condition is Conditions as text, for templates
+func (s Summary) Condition() string { return describeConditions(s.Conditions) }
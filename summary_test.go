@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSummarise(t *testing.T) {
+	ir := New()
+	pantry := &ir.Report.Rooms[1]
+	pantry.Cases = append(pantry.Cases, Case{Title: "Tap fixed", Category: "Repair", Status: "RESOLVED"})
+	structureInventory(&ir)
+
+	s := summarise(ir.Report)
+	if s.Cases != 5 || s.OpenCases != 4 {
+		t.Errorf("Cases = %d, OpenCases = %d", s.Cases, s.OpenCases)
+	}
+	if want := []Tally{{"Confirmed", 3}, {"RESOLVED", 1}, {"Reopened", 1}}; !reflect.DeepEqual(s.CasesByStatus, want) {
+		t.Errorf("CasesByStatus = %+v, want %+v", s.CasesByStatus, want)
+	}
+	if s.ByCategory() != "Repair 3, Complex project 1, Reference 1" {
+		t.Errorf("ByCategory() = %q", s.ByCategory())
+	}
+	if s.InventoryItems != 34 || s.ItemsInPoorCondition != 1 {
+		t.Errorf("InventoryItems = %d, ItemsInPoorCondition = %d", s.InventoryItems, s.ItemsInPoorCondition)
+	}
+	if want := []string{"Big Meeting Room", "Pantry"}; !reflect.DeepEqual(s.RoomsWithOpenIssues, want) {
+		t.Errorf("RoomsWithOpenIssues = %q", s.RoomsWithOpenIssues)
+	}
+}
+
+func TestGenHTMLSummary(t *testing.T) {
+	mem := NewMemoryStorage("http://localhost/media")
+	store = mem
+	defer func() { store = nil }()
+
+	output, err := genHTML(New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Summary == nil || output.Summary.Cases == 0 {
+		t.Fatalf("output.Summary = %+v", output.Summary)
+	}
+
+	dumpJSON, _ := mem.Get(strings.TrimPrefix(output.JSON, "http://localhost/media/"))
+	var ir InspectionReport
+	json.Unmarshal(dumpJSON, &ir)
+	if !reflect.DeepEqual(ir.Summary, output.Summary) {
+		t.Errorf("dumped summary = %+v", ir.Summary)
+	}
+	html, _ := mem.Get(strings.TrimPrefix(output.HTML, "http://localhost/media/"))
+	if !strings.Contains(string(html), `<section id="summary">`) {
+		t.Error("summary not rendered")
+	}
+	// Not to be mistaken for the number of entries shown per room
+	if !strings.Contains(string(html), "<td>Items (total quantity)</td>") {
+		t.Error("total quantity not labelled as such")
+	}
+}
@@ -28,8 +28,7 @@ new Vue({
   data: {
     signaturesNeeded: 2,
     html: '',
-    pdf: '', // pdf.cool
-    ppdf: '', // Prince PDF
+    pdf: '',
     json: '',
     jsonurl: new URL(location.href).searchParams.get('jsonurl') || '/templates/dump.json',
     // all signature urls as example
@@ -46,22 +45,18 @@ new Vue({
         headers: { 'X-CSRF-Token': x.target.elements['gorilla.csrf.Token'] ? x.target.elements['gorilla.csrf.Token'].value : '' },
         body: new FormData(x.target) })
         .then((result) => { return result.json() })
+      if (result.errors) {
+        alert(result.errors.map((e) => e.field + ': ' + e.message).join('\n'))
+        return
+      }
       this.html = result.HTML
+      this.pdf = result.PDF
       this.jsonurl = result.JSON
 
       var params = new URLSearchParams(window.location.search)
       params.set('jsonurl', this.jsonurl)
       const path = window.location.protocol + '//' + window.location.host + window.location.pathname + '?' + params.toString()
       window.history.pushState({path}, '', path)
-
-      const url = new URL(window.location)
-      var princeURL = url.origin.replace('pdfgen', 'prince')
-      if (url.hostname === 'localhost') { princeURL = 'https://prince.dev.unee-t.com' }
-      fetch(princeURL, { method: 'POST',
-        credentials: 'same-origin',
-        body: JSON.stringify({ 'document_url': this.html })})
-        .then(stream => stream.json())
-        .then(pdf => this.ppdf = pdf.PDF)
     },
     async submitJson (x) {
       console.log('Submitting JSON', this.json)
@@ -70,22 +65,18 @@ new Vue({
         headers: { 'X-CSRF-Token': x.target.elements['gorilla.csrf.Token'] ? x.target.elements['gorilla.csrf.Token'].value : '' },
         body: this.json })
         .then((result) => { return result.json() })
+      if (result.errors) {
+        alert(result.errors.map((e) => e.field + ': ' + e.message).join('\n'))
+        return
+      }
       this.html = result.HTML
+      this.pdf = result.PDF
       this.jsonurl = result.JSON
 
       var params = new URLSearchParams(window.location.search)
       params.set('jsonurl', this.jsonurl)
       const path = window.location.protocol + '//' + window.location.host + window.location.pathname + '?' + params.toString()
       window.history.pushState({path}, '', path)
-
-      fetch(`/pdfgen?url=${result.HTML}`)
-        .then(stream => stream.json())
-        .then(pdf => this.pdf = pdf.PDF)
-      fetch('https://prince.dev.unee-t.com/', { method: 'POST',
-        credentials: 'same-origin',
-        body: JSON.stringify({ 'document_url': this.html })})
-        .then(stream => stream.json())
-        .then(pdf => this.ppdf = pdf.PDF)
     },
     updateSignature (index, url) {
       Vue.set(this.signatureDataUris, index, url)
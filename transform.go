@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// ImageTransform is what a template asks of an image, whoever serves it.
+// Templates write it in Cloudinary's notation, e.g. "c_fill,g_auto,h_500,w_500".
+type ImageTransform struct {
+	Width, Height int
+	Crop          string // fill covers Width x Height cropping the overflow, otherwise the image fits within
+	Gravity       string // auto lets the provider keep the subject, the centre otherwise
+	Format        string // auto lets the provider pick the best format for the browser
+
+	other []string // Parameters only Cloudinary understands, e.g. q_auto
+}
+
+// parseTransform reads a transform written in Cloudinary notation
+func parseTransform(spec string) (t ImageTransform) {
+	for _, p := range strings.Split(spec, ",") {
+		if len(p) < 3 || p[1] != '_' {
+			if p != "" {
+				t.other = append(t.other, p)
+			}
+			continue
+		}
+		value := p[2:]
+		switch p[0] {
+		case 'w':
+			t.Width, _ = strconv.Atoi(value)
+		case 'h':
+			t.Height, _ = strconv.Atoi(value)
+		case 'c':
+			t.Crop = value
+		case 'g':
+			t.Gravity = value
+		case 'f':
+			t.Format = value
+		default:
+			t.other = append(t.other, p)
+		}
+	}
+	return t
+}
+
+// String is t in Cloudinary's notation, parameters sorted as Cloudinary writes them
+func (t ImageTransform) String() string {
+	params := append([]string(nil), t.other...)
+	for prefix, value := range map[string]string{"c_": t.Crop, "g_": t.Gravity, "f_": t.Format} {
+		if value != "" {
+			params = append(params, prefix+value)
+		}
+	}
+	if t.Width > 0 {
+		params = append(params, "w_"+strconv.Itoa(t.Width))
+	}
+	if t.Height > 0 {
+		params = append(params, "h_"+strconv.Itoa(t.Height))
+	}
+	sort.Strings(params)
+	return strings.Join(params, ",")
+}
+
+// ImageTransformer rewrites an image URL so its host applies a transform
+type ImageTransformer interface {
+	Transform(u *neturl.URL, t ImageTransform) string
+}
+
+// Cloudinary puts the transform in the path, on res.cloudinary.com or any
+// host mapped to it such as a CNAME, see CloudinaryTransform
+type Cloudinary struct{}
+
+// Transform implements ImageTransformer
+func (Cloudinary) Transform(u *neturl.URL, t ImageTransform) string {
+	return cloudinaryURL(u, t.String())
+}
+
+// Imgix puts the transform in the query string, as imgix and most resizing CDNs do
+type Imgix struct{}
+
+// Transform implements ImageTransformer
+func (Imgix) Transform(u *neturl.URL, t ImageTransform) string {
+	q := u.Query()
+	if t.Width > 0 {
+		q.Set("w", strconv.Itoa(t.Width))
+	}
+	if t.Height > 0 {
+		q.Set("h", strconv.Itoa(t.Height))
+	}
+	switch t.Crop {
+	case "fill":
+		q.Set("fit", "crop")
+		if t.Gravity == "auto" {
+			q.Set("crop", "faces,entropy")
+		}
+	case "":
+	default:
+		q.Set("fit", "max")
+	}
+	if t.Format == "auto" {
+		q.Set("auto", "format")
+	}
+	v := *u
+	v.RawQuery = q.Encode()
+	return v.String()
+}
+
+// Thumbnailer resizes images itself, for hosts that cannot, see handleThumbnail.
+// Its links are signed, so it only resizes what reports show. Without a URL
+// and a Key, or for hosts it may not fetch from, images are shown at their
+// full size.
+type Thumbnailer struct {
+	URL string // THUMBNAIL_URL, where handleThumbnail is served, e.g. https://pdfgen.dev.unee-t.com/thumbnail
+	Key []byte // THUMBNAIL_KEY, signing the links
+}
+
+// Transform implements ImageTransformer
+func (th *Thumbnailer) Transform(u *neturl.URL, t ImageTransform) string {
+	if th.URL == "" || len(th.Key) == 0 || (t.Width == 0 && t.Height == 0) {
+		return u.String()
+	}
+	if err := imageEmbedder.Fetcher.Check(u.String()); err != nil {
+		return u.String()
+	}
+	q := neturl.Values{"src": {u.String()}}
+	if t.Width > 0 {
+		q.Set("w", strconv.Itoa(t.Width))
+	}
+	if t.Height > 0 {
+		q.Set("h", strconv.Itoa(t.Height))
+	}
+	if t.Crop == "fill" {
+		q.Set("fit", "fill")
+	}
+	q.Set("sig", th.sign(q))
+	return th.URL + "?" + q.Encode()
+}
+
+// sign is the HMAC of the source and size of a thumbnail
+func (th *Thumbnailer) sign(q neturl.Values) string {
+	mac := hmac.New(sha256.New, th.Key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", q.Get("src"), q.Get("w"), q.Get("h"), q.Get("fit"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// valid tells whether q was signed by Transform
+func (th *Thumbnailer) valid(q neturl.Values) bool {
+	if len(th.Key) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(q.Get("sig")), []byte(th.sign(q)))
+}
+
+// hostTransformer is the ImageTransformer of a host, or a domain when starting with a dot
+type hostTransformer struct {
+	host        string
+	transformer ImageTransformer
+}
+
+// imageTransformers are configured from IMAGE_TRANSFORMS, e.g.
+// "res.cloudinary.com=cloudinary,.imgix.net=imgix". Other hosts use the Thumbnailer.
+var imageTransformers = hostTransformers(os.Getenv("IMAGE_TRANSFORMS"))
+
+var thumbnailer = &Thumbnailer{URL: os.Getenv("THUMBNAIL_URL")}
+
+func hostTransformers(config string) (ts []hostTransformer) {
+	if config == "" {
+		config = "res.cloudinary.com=cloudinary,.imgix.net=imgix"
+	}
+	for _, entry := range strings.Split(config, ",") {
+		i := strings.IndexByte(entry, '=')
+		if i < 0 {
+			continue
+		}
+		host, provider := strings.ToLower(strings.TrimSpace(entry[:i])), strings.TrimSpace(entry[i+1:])
+		switch provider {
+		case "cloudinary":
+			ts = append(ts, hostTransformer{host, Cloudinary{}})
+		case "imgix":
+			ts = append(ts, hostTransformer{host, Imgix{}})
+		case "thumbnail":
+			ts = append(ts, hostTransformer{host, thumbnailer})
+		}
+	}
+	return ts
+}
+
+// transformerFor picks the ImageTransformer of host
+func transformerFor(host string) ImageTransformer {
+	host = strings.ToLower(host)
+	for _, t := range imageTransformers {
+		if t.host == host || (strings.HasPrefix(t.host, ".") && strings.HasSuffix(host, t.host)) {
+			return t.transformer
+		}
+	}
+	return thumbnailer
+}
+
+// transformImage is the "transform" template function, working for any http(s) image URL
+func transformImage(url, spec string) string {
+	u, err := neturl.ParseRequestURI(url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return transformerFor(u.Hostname()).Transform(u, parseTransform(spec))
+}
+
+// maxThumbnail is the largest side handleThumbnail makes, in pixels
+const maxThumbnail = 2000
+
+// thumbnailKey is where handleThumbnail keeps the thumbnail signed sig
+func thumbnailKey(sig string) string {
+	return "thumbnails/" + sig
+}
+
+// handleThumbnail serves ?src= resized to ?w= and ?h=, filling both when ?fit=fill,
+// as signed by Thumbnailer in ?sig=. Sources are fetched like embedded images,
+// so only from IMAGE_HOSTS, and resized once.
+func handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if !thumbnailer.valid(q) {
+		http.Error(w, "sig does not match the thumbnail", http.StatusForbidden)
+		return
+	}
+	width, _ := strconv.Atoi(q.Get("w"))
+	height, _ := strconv.Atoi(q.Get("h"))
+	if width < 0 || height < 0 || width > maxThumbnail || height > maxThumbnail || width+height == 0 {
+		http.Error(w, fmt.Sprintf("w and h must be at most %d, and one of them set", maxThumbnail), http.StatusBadRequest)
+		return
+	}
+	key := thumbnailKey(q.Get("sig"))
+	if data, err := store.Get(key); err == nil {
+		writeThumbnail(w, http.DetectContentType(data), data)
+		return
+	}
+	body, err := imageEmbedder.Fetcher.Fetch(q.Get("src"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err == nil && cfg.Width*cfg.Height > imageEmbedder.MaxPixels {
+		err = fmt.Errorf("%dx%d is larger than %d pixels", cfg.Width, cfg.Height, imageEmbedder.MaxPixels)
+	}
+	var img image.Image
+	if err == nil {
+		img, _, err = image.Decode(bytes.NewReader(body))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	b := img.Bounds()
+	if width == 0 {
+		width = b.Dx() * height / b.Dy()
+	}
+	if height == 0 {
+		height = b.Dy() * width / b.Dx()
+	}
+	var thumb *embeddedImage
+	if q.Get("fit") == "fill" {
+		thumb, err = encodeImage(fillTo(img, width, height))
+	} else {
+		// Within the box, keeping the proportions
+		if b.Dx()*height > b.Dy()*width {
+			height = b.Dy() * width / b.Dx()
+		} else {
+			width = b.Dx() * height / b.Dy()
+		}
+		thumb, err = encodeImage(resample(img, b, width, height))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := store.Put(key, thumb.data, thumb.contentType, Private); err != nil {
+		log.WithError(err).Warnf("caching thumbnail of %s", q.Get("src"))
+	}
+	writeThumbnail(w, thumb.contentType, thumb.data)
+}
+
+func writeThumbnail(w http.ResponseWriter, contentType string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Write(data)
+}
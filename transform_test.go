@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"testing"
+)
+
+func TestTransformImage(t *testing.T) {
+	defer func(th *Thumbnailer, ts []hostTransformer) { thumbnailer, imageTransformers = th, ts }(thumbnailer, imageTransformers)
+	defer func(old *Embedder) { imageEmbedder = old }(imageEmbedder)
+	imageEmbedder = &Embedder{Fetcher: &Fetcher{AllowedHosts: []string{"media.s3.amazonaws.com"}}}
+	thumbnailer = &Thumbnailer{URL: "https://pdfgen.unee-t.com/thumbnail", Key: []byte("key")}
+	imageTransformers = hostTransformers("res.cloudinary.com=cloudinary,photos.unee-t.com=cloudinary,.imgix.net=imgix,images.example.com=imgix")
+
+	tests := []struct {
+		url, spec, want string
+	}{
+		{
+			"http://res.cloudinary.com/unee-t-staging/image/upload/c_fill,g_auto,h_150,w_150/Unee-T%20inspection%20report%20-%20placeholder%20images/table_succulent.jpg",
+			"c_fill,g_auto,h_500,w_500",
+			"https://res.cloudinary.com/unee-t-staging/c_fill,g_auto,h_500,w_500/Unee-T%20inspection%20report%20-%20placeholder%20images/table_succulent.jpg",
+		},
+		{
+			"https://photos.unee-t.com/unee-t-staging/image/upload/v1534/units/kitchen.jpg",
+			"c_fill,g_auto,h_500,w_500",
+			"https://photos.unee-t.com/unee-t-staging/c_fill,g_auto,h_500,w_500/units/kitchen.jpg",
+		},
+		{
+			"https://unee-t.imgix.net/units/kitchen.jpg?dpr=2",
+			"c_fill,g_auto,h_500,w_500",
+			"https://unee-t.imgix.net/units/kitchen.jpg?crop=faces%2Centropy&dpr=2&fit=crop&h=500&w=500",
+		},
+		{"https://images.example.com/kitchen.jpg", "f_auto", "https://images.example.com/kitchen.jpg?auto=format"},
+		{
+			"https://media.s3.amazonaws.com/kitchen.jpg",
+			"c_fill,g_auto,h_500,w_500",
+			"https://pdfgen.unee-t.com/thumbnail?fit=fill&h=500&sig=ce49a8e7357bcb7617756686222c6374c7ffe4c87419bb889178beed698c4b65&src=https%3A%2F%2Fmedia.s3.amazonaws.com%2Fkitchen.jpg&w=500",
+		},
+		// Not fetched by the thumbnailer, so not sent to it
+		{"https://elsewhere.example.com/kitchen.jpg", "c_fill,g_auto,h_500,w_500", "https://elsewhere.example.com/kitchen.jpg"},
+		{"https://media.s3.amazonaws.com/kitchen.jpg", "f_auto", "https://media.s3.amazonaws.com/kitchen.jpg"},
+		{"Unee-T%20inspection%20report%20-%20placeholder%20images/table_succulent.jpg", "f_auto", ""},
+		{"javascript:alert(1)", "f_auto", ""},
+	}
+	for _, tt := range tests {
+		if got := transformImage(tt.url, tt.spec); got != tt.want {
+			t.Errorf("transformImage(%q, %q) = %v, want %v", tt.url, tt.spec, got, tt.want)
+		}
+	}
+
+	// Without THUMBNAIL_URL, other hosts are shown at full size rather than not at all
+	thumbnailer = &Thumbnailer{}
+	imageTransformers = hostTransformers("")
+	if got := transformImage("https://media.s3.amazonaws.com/kitchen.jpg", "c_fill,g_auto,h_500,w_500"); got != "https://media.s3.amazonaws.com/kitchen.jpg" {
+		t.Errorf("transformImage() = %v, want the image untransformed", got)
+	}
+}
+
+func TestHandleThumbnail(t *testing.T) {
+	em, srv := testEmbedder(t)
+	defer srv.Close()
+	defer func(old *Embedder) { imageEmbedder = old }(imageEmbedder)
+	imageEmbedder = em
+	defer func(th *Thumbnailer) { thumbnailer = th }(thumbnailer)
+	thumbnailer = &Thumbnailer{URL: "http://localhost/thumbnail", Key: []byte("key")}
+	store = NewMemoryStorage("http://localhost/media")
+	defer func() { store = nil }()
+
+	tests := []struct {
+		query         string
+		status        int
+		width, height int
+	}{
+		{"w=100&h=100&fit=fill", http.StatusOK, 100, 100},
+		{"w=100&h=100&fit=fill", http.StatusOK, 100, 100}, // Cached
+		{"w=100&h=100", http.StatusOK, 100, 50},
+		{"h=60", http.StatusOK, 120, 60},
+		{"w=5000", http.StatusBadRequest, 0, 0},
+		{"w=100&src=" + neturl.QueryEscape(srv.URL+"/missing.jpg"), http.StatusBadGateway, 0, 0},
+		{"w=100&src=" + neturl.QueryEscape("http://169.254.169.254/latest/meta-data"), http.StatusBadGateway, 0, 0},
+	}
+	for _, tt := range tests {
+		q, _ := neturl.ParseQuery(tt.query)
+		if tt.status == http.StatusOK {
+			q.Set("src", srv.URL+"/large.png")
+		}
+		q.Set("sig", thumbnailer.sign(q))
+		query := q.Encode()
+		rec := httptest.NewRecorder()
+		handleThumbnail(rec, httptest.NewRequest("GET", "/thumbnail?"+query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.query, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/jpeg" {
+			t.Errorf("%s: Content-Type = %s, want image/jpeg for an opaque image", tt.query, ct)
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil || cfg.Width != tt.width || cfg.Height != tt.height {
+			t.Errorf("%s: thumbnail is %dx%d, %v, want %dx%d", tt.query, cfg.Width, cfg.Height, err, tt.width, tt.height)
+		}
+	}
+
+	// Only what Thumbnailer signed is resized
+	for _, query := range []string{"w=100&src=" + neturl.QueryEscape(srv.URL+"/large.png"), "w=100&src=" + neturl.QueryEscape(srv.URL+"/large.png") + "&sig=00"} {
+		rec := httptest.NewRecorder()
+		handleThumbnail(rec, httptest.NewRequest("GET", "/thumbnail?"+query, nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want %d", query, rec.Code, http.StatusForbidden)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestResolveUnit(t *testing.T) {
+	d := NewMemoryDirectory()
+	if err := d.LoadFile("tests/units.json"); err != nil {
+		t.Fatal(err)
+	}
+	units = d
+	defer func() { units = nil }()
+
+	ir := New()
+	want := ir.Unit.Information
+	ir.Unit = Unit{ID: "unit-01-02"}
+	if err := resolveUnit(&ir); err != nil {
+		t.Fatal(err)
+	}
+	if ir.Unit.Information != want {
+		t.Errorf("Information = %+v, want %+v", ir.Unit.Information, want)
+	}
+
+	ir.Unit = Unit{ID: "unit-01-02", Information: Information{Name: "Unit 1-2"}}
+	if err := resolveUnit(&ir); err != nil {
+		t.Fatal(err)
+	}
+	if ir.Unit.Information.Name != "Unit 1-2" || ir.Unit.Information.City != "San Pedro" {
+		t.Errorf("Information = %+v, want the name sent and the rest from the directory", ir.Unit.Information)
+	}
+
+	ir.Unit = Unit{ID: "unit-99"}
+	err := resolveUnit(&ir)
+	if want := `unit.id: no unit "unit-99" in the directory`; err == nil || err.Error() != want {
+		t.Errorf("resolveUnit() = %v, want %s", err, want)
+	}
+
+	units = nil
+	ir.Unit = Unit{ID: "unit-01-02"}
+	if err := resolveUnit(&ir); err == nil {
+		t.Error("resolveUnit() without a directory = nil, want an error")
+	}
+}
+
+func TestMongoDirectory(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("lookup", func(mt *mtest.T) {
+		d := &MongoDirectory{Collection: mt.Coll, Timeout: time.Second}
+		ns := mt.Coll.Database().Name() + "." + mt.Coll.Name()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: "unit-01-02"},
+			{Key: "bzId", Value: 77},
+			{Key: "displayName", Value: "Unit 01-02"},
+			{Key: "unitType", Value: "Apartment/Flat"},
+			{Key: "streetAddress", Value: "20 Maple Avenue"},
+			{Key: "zipCode", Value: "90731"},
+			{Key: "city", Value: "San Pedro"},
+			{Key: "state", Value: "California"},
+			{Key: "country", Value: "USA"},
+			{Key: "moreInfo", Value: "Blue house"},
+		}))
+		got, err := d.Lookup("unit-01-02")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		want := Information{Name: "Unit 01-02", Type: "Apartment/Flat", Address: "20 Maple Avenue", Postcode: "90731",
+			City: "San Pedro", State: "California", Country: "USA", Description: "Blue house"}
+		if !reflect.DeepEqual(got, want) {
+			mt.Errorf("Lookup() = %+v, want %+v", got, want)
+		}
+		if filter := mt.GetStartedEvent().Command.Lookup("filter").String(); filter != `{"_id": "unit-01-02"}` {
+			mt.Errorf("filter = %s", filter)
+		}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+		if _, err := d.Lookup("unit-99"); err != ErrUnitNotFound {
+			mt.Errorf("Lookup() missing = %v, want ErrUnitNotFound", err)
+		}
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 13, Message: "unauthorized"}))
+		if _, err := d.Lookup("unit-01-02"); err == nil || err == ErrUnitNotFound {
+			mt.Errorf("Lookup() unauthorised = %v, want an error", err)
+		}
+	})
+}
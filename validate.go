@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tj/go/http/response"
+)
+
+// FieldError is a problem with one field of a report
+type FieldError struct {
+	Field   string `json:"field"` // JSON path, e.g. report.rooms[2].cases[0].title
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors is everything wrong with a report, in document order
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (v *ValidationErrors) add(field, format string, args ...interface{}) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateReport checks ir against reportSchema, then what the schema cannot
+// express, returning ValidationErrors if ir cannot be rendered
+func validateReport(ir InspectionReport) error {
+	v, err := validateSchema(ir)
+	if err != nil {
+		return err
+	}
+
+	if ir.Template != "" && !strings.HasPrefix(ir.Template, "http://") && !strings.HasPrefix(ir.Template, "https://") {
+		if _, _, err := parseTemplateRef(ir.Template); err != nil {
+			v.add("template", "must be a URL or a registered template such as handover@v3")
+		}
+	}
+	if strings.HasPrefix(ir.Callback, "http://") || strings.HasPrefix(ir.Callback, "https://") {
+		if err := webhook.Fetcher.Check(ir.Callback); err != nil {
+			v.add("callback", "%v, see WEBHOOK_HOSTS", err)
+		}
+	}
+	if ir.Bugzilla != nil && bugzilla.URL == "" {
+		v.add("bugzilla", "cases cannot be imported, this service has no BUGZILLA_URL")
+	}
+	validateCreator(ir, &v)
+	validateAssignees(ir, &v)
+	for i, s := range ir.Signatures {
+		if s.DataURI == "" {
+			continue // Reported by the schema
+		}
+		if msg := signatureProblem(string(s.DataURI)); msg != "" {
+			v.add(fmt.Sprintf("signatures[%d].data_uri", i), "%s", msg)
+		}
+	}
+
+	if len(v) > 0 {
+		return v
+	}
+	return nil
+}
+
+// prepareReport normalises the signatures of ir then validates it, see
+// normaliseSignatures. The fields of ir the schema does not know, which
+// upgradeReport ignores unless ir has a schema_version, are warned about.
+func prepareReport(ir *InspectionReport) (warnings []FieldError, err error) {
+	if ir.doc != nil {
+		var unknown ValidationErrors
+		reportSchema.unknownFields(reportSchema, ir.doc, "", &unknown)
+		for _, u := range unknown {
+			warnings = append(warnings, FieldError{Field: u.Field, Message: "unknown field, ignored"})
+		}
+	}
+	normalised, err := normaliseSignatures(ir)
+	warnings = append(warnings, normalised...)
+	if err != nil {
+		return warnings, err
+	}
+	return warnings, validateReport(*ir)
+}
+
+// validationFailed replies 422 with the field errors of err
+func validationFailed(w http.ResponseWriter, err error) {
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	response.JSON(w, map[string]interface{}{
+		"error":  "report is not valid",
+		"schema": schemaPath,
+		"errors": errs,
+	}, http.StatusUnprocessableEntity)
+}
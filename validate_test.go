@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testReport is templates/dump.json, a report that passes validation
+func testReport(t *testing.T) InspectionReport {
+	byteValue, err := ioutil.ReadFile("templates/dump.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ir InspectionReport
+	if err := json.Unmarshal(byteValue, &ir); err != nil {
+		t.Fatal(err)
+	}
+	return ir
+}
+
+func TestValidateReport(t *testing.T) {
+	if err := validateReport(testReport(t)); err != nil {
+		t.Fatalf("templates/dump.json: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(ir *InspectionReport)
+		want   []string
+	}{
+		{"empty ID and date", func(ir *InspectionReport) { ir.ID, ir.Date = "", time.Time{} }, []string{"id: required", "date: required"}},
+		{"ID with a path", func(ir *InspectionReport) { ir.ID = "../secret" }, []string{"id: must be letters, digits, ., - or _"}},
+		{"case title", func(ir *InspectionReport) { ir.Report.Rooms[0].Cases[1].Title = " " }, []string{"report.rooms[0].cases[1].title: required"}},
+		{"item name", func(ir *InspectionReport) { ir.Report.Rooms[1].Inventory[3].Name = "" }, []string{"report.rooms[1].inventory[3].name: required"}},
+		{"image URL", func(ir *InspectionReport) { ir.Report.Images[1] = "table_succulent.jpg" }, []string{"report.images[1]: must be an absolute http or https URL"}},
+		{"no signatures", func(ir *InspectionReport) { ir.Signatures = nil }, []string{"signatures: at least 1 required"}},
+		{"too many signatures", func(ir *InspectionReport) {
+			for len(ir.Signatures) <= 12 {
+				ir.Signatures = append(ir.Signatures, ir.Signatures[0])
+			}
+		}, []string{"signatures: at most 12 allowed"}},
+		{"missing data URI", func(ir *InspectionReport) { ir.Signatures[1].DataURI = "" }, []string{"signatures[1].data_uri: required"}},
+		{"not an image", func(ir *InspectionReport) { ir.Signatures[0].DataURI = "data:text/plain;base64,aGk=" }, []string{`signatures[0].data_uri: must be an image, not "text/plain"`}},
+		{"not base64", func(ir *InspectionReport) { ir.Signatures[0].DataURI = "data:image/png,abc" }, []string{"signatures[0].data_uri: must be a base64 data URI: data URI is not base64 encoded"}},
+		{"template", func(ir *InspectionReport) { ir.Template = "Handover@3" }, []string{"template: must be a URL or a registered template such as handover@v3"}},
+		{"callback", func(ir *InspectionReport) { ir.Callback = "ftp://example.com" }, []string{"callback: must be an absolute http or https URL"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ir := testReport(t)
+			tt.modify(&ir)
+			errs, _ := validateReport(ir).(ValidationErrors)
+			var got []string
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateReport() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleJSONValidation(t *testing.T) {
+	ir := testReport(t)
+	ir.Report.Rooms[0].Cases[0].Title = ""
+	body, _ := json.Marshal(ir)
+
+	w := httptest.NewRecorder()
+	handleJSON(w, httptest.NewRequest("POST", "/", strings.NewReader(string(body))))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("POST / = %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Errors []FieldError `json:"errors"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Field != "report.rooms[0].cases[0].title" {
+		t.Errorf("errors = %+v", resp.Errors)
+	}
+}
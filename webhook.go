@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// Webhook events
+const (
+	EventReportSucceeded = "report.succeeded"
+	EventReportFailed    = "report.failed"
+)
+
+// Webhook delivers signed completion notices to a callback URL. Callbacks
+// are requested like remote templates, so only on WEBHOOK_HOSTS and never on
+// an address inside our network. They are delivered by whoever rendered the
+// report, before it replies or picks its next job, as an instance may be
+// frozen as soon as it has replied.
+type Webhook struct {
+	Secret   string // WEBHOOK_SECRET, signs the body as X-Signature, nothing is sent without it
+	Fetcher  *Fetcher
+	Attempts int
+	Backoff  time.Duration // doubled after each failed attempt
+}
+
+// webhook is configured in main
+var webhook = Webhook{
+	Fetcher: &Fetcher{
+		AllowedHosts: webhookHosts(),
+		Timeout:      10 * time.Second,
+		NoCache:      true,
+	},
+	Attempts: 5,
+	Backoff:  time.Second,
+}
+
+// webhookHosts are WEBHOOK_HOSTS, a comma separated list, and the host of WEBHOOK_URL
+func webhookHosts() []string {
+	hosts := []string{".unee-t.com"}
+	if h := os.Getenv("WEBHOOK_HOSTS"); h != "" {
+		hosts = strings.Split(h, ",")
+	}
+	if u, err := neturl.Parse(os.Getenv("WEBHOOK_URL")); err == nil && u.Hostname() != "" {
+		hosts = append(hosts, u.Hostname())
+	}
+	return hosts
+}
+
+// WebhookEvent is the body POSTed to the callback URL
+type WebhookEvent struct {
+	Event    string        `json:"event"`
+	ReportID string        `json:"report_id"`
+	JobID    string        `json:"job_id,omitempty"`
+	Output   *responseHTML `json:"output,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Sent     time.Time     `json:"sent"`
+}
+
+func completionEvent(ir InspectionReport, jobID string, output responseHTML, err error) WebhookEvent {
+	if err != nil {
+		return WebhookEvent{Event: EventReportFailed, ReportID: ir.ID, JobID: jobID, Error: err.Error()}
+	}
+	return WebhookEvent{Event: EventReportSucceeded, ReportID: output.ID, JobID: jobID, Output: &output}
+}
+
+// callbackURL is the report's own callback, else WEBHOOK_URL
+func callbackURL(ir InspectionReport) string {
+	if ir.Callback != "" {
+		return ir.Callback
+	}
+	return os.Getenv("WEBHOOK_URL")
+}
+
+// notify delivers ev to the report's callback, if there is one
+func notify(ir InspectionReport, ev WebhookEvent) {
+	callback := callbackURL(ir)
+	if callback == "" {
+		return
+	}
+	if err := webhook.Deliver(callback, ev); err != nil {
+		log.WithError(err).WithField("callback", callback).Errorf("webhook for %s", ev.ReportID)
+	}
+}
+
+// errNoWebhookSecret is returned by Deliver rather than sending a callback
+// its receiver cannot authenticate
+var errNoWebhookSecret = errors.New("WEBHOOK_SECRET is not set, not sending an unsigned callback")
+
+// signPayload is the hex HMAC-SHA256 of body, sent as X-Signature: sha256=...
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver POSTs ev to callback, retrying with exponential backoff on
+// network errors, 5xx and 429 responses
+func (h Webhook) Deliver(callback string, ev WebhookEvent) error {
+	if h.Secret == "" {
+		return errNoWebhookSecret
+	}
+	if err := h.Fetcher.Check(callback); err != nil {
+		return err
+	}
+	ev.Sent = time.Now()
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	backoff := h.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := h.post(callback, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= h.Attempts {
+			return fmt.Errorf("after %d attempts: %v", attempt, err)
+		}
+		log.WithError(err).Warnf("webhook attempt %d, retrying in %s", attempt, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (h Webhook) post(callback string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", callback, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature", "sha256="+signPayload(h.Secret, body))
+
+	resp, err := h.Fetcher.Do(req)
+	if err != nil {
+		// Retrying cannot make a blocked address public
+		return !strings.Contains(err.Error(), errBlockedAddress.Error()), err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("callback responded %s", resp.Status)
+	default:
+		return false, fmt.Errorf("callback responded %s", resp.Status)
+	}
+}
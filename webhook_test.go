@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestWebhookDeliver(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "first time", statuses: []int{200}, wantAttempts: 1},
+		{name: "retries server errors", statuses: []int{500, 503, 204}, wantAttempts: 3},
+		{name: "gives up", statuses: []int{500, 500, 500, 500}, wantAttempts: 3, wantErr: true},
+		{name: "no retry on client error", statuses: []int{400, 200}, wantAttempts: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int
+			var got WebhookEvent
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if sig := r.Header.Get("X-Signature"); sig != "sha256="+signPayload("secret", body) {
+					t.Errorf("bad signature %q", sig)
+				}
+				json.Unmarshal(body, &got)
+				w.WriteHeader(tt.statuses[attempts])
+				attempts++
+			}))
+			defer srv.Close()
+
+			h := Webhook{Secret: "secret", Fetcher: &Fetcher{AllowedHosts: []string{"127.0.0.1"}, AllowPrivate: true, Timeout: time.Second}, Attempts: 3, Backoff: time.Millisecond}
+			ev := completionEvent(InspectionReport{ID: "12345678"}, "job1", responseHTML{ID: "12345678-abcd"}, nil)
+			err := h.Deliver(srv.URL, ev)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Deliver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if got.Event != EventReportSucceeded || got.ReportID != "12345678-abcd" || got.JobID != "job1" {
+				t.Errorf("received %+v", got)
+			}
+		})
+	}
+}
+
+func TestWebhookRefusesInternalCallbacks(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	h := Webhook{Secret: "secret", Fetcher: &Fetcher{AllowedHosts: []string{"127.0.0.1", ".example.com"}, Timeout: time.Second}, Attempts: 3, Backoff: time.Millisecond}
+	for _, callback := range []string{
+		"http://169.254.169.254/latest/meta-data/",
+		"http://127.0.0.1/hook",
+		"http://127.0.0.1:" + u.Port() + "/",
+		"https://evil.example.org/hook",
+	} {
+		if err := h.Deliver(callback, WebhookEvent{}); err == nil {
+			t.Errorf("Deliver(%s) succeeded", callback)
+		}
+	}
+	if attempts != 0 {
+		t.Errorf("%d requests reached the internal server", attempts)
+	}
+}
+
+func TestWebhookRequiresSecret(t *testing.T) {
+	var attempts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+	}))
+	defer srv.Close()
+
+	h := Webhook{Fetcher: &Fetcher{AllowedHosts: []string{"127.0.0.1"}, AllowPrivate: true, Timeout: time.Second}, Attempts: 3, Backoff: time.Millisecond}
+	if err := h.Deliver(srv.URL, WebhookEvent{}); err != errNoWebhookSecret {
+		t.Errorf("Deliver() error = %v, want errNoWebhookSecret", err)
+	}
+	if attempts != 0 {
+		t.Errorf("%d unsigned requests were sent", attempts)
+	}
+}
+
+func TestCompletionEventFailed(t *testing.T) {
+	ev := completionEvent(InspectionReport{ID: "12345678"}, "", responseHTML{}, errors.New("boom"))
+	if ev.Event != EventReportFailed || ev.ReportID != "12345678" || ev.Error != "boom" || ev.Output != nil {
+		t.Errorf("completionEvent() = %+v", ev)
+	}
+}